@@ -0,0 +1,42 @@
+// migratewidgets переписывает сохраненные запросы виджетов в типизированном виде
+// и печатает виджеты, запросы которых не удалось разобрать, их нужно исправить вручную.
+// Приложение не запускается, пока миграция не выполнена. Виджеты с неразобранными запросами
+// возвращаются с queryError, пока их запросы не сохранят заново.
+package main
+
+import (
+	"context"
+	"datapointbackend/config"
+	"datapointbackend/internal/repository"
+	"datapointbackend/pkg/database"
+	"fmt"
+	"os"
+)
+
+func main() {
+	cfg, err := config.New()
+	if err != nil {
+		panic(err.Error())
+	}
+
+	db, err := database.New(cfg.Database)
+	if err != nil {
+		panic(err.Error())
+	}
+	defer func() { _ = db.Close() }()
+
+	migrated, failed, err := repository.NewWidgetRepository(db).MigrateQueries(context.Background())
+	if err != nil {
+		panic(err.Error())
+	}
+
+	fmt.Printf("переписано запросов: %d, не удалось разобрать: %d\n", migrated, len(failed))
+
+	for id, err := range failed {
+		fmt.Printf("%s: %s\n", id, err.Error())
+	}
+
+	if len(failed) != 0 {
+		os.Exit(1)
+	}
+}
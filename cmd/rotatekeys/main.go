@@ -0,0 +1,55 @@
+// rotatekeys перешифровывает секреты источников текущим мастер-ключом.
+//
+// Ротация: новый ключ задается в DATAPOINT_MASTER_KEY, прежний - в DATAPOINT_PREVIOUS_MASTER_KEYS,
+// после перешифрования прежний ключ больше не нужен. С флагом -generate печатает новый мастер-ключ.
+// Секреты, сохраненные до включения шифрования, тоже шифруются.
+package main
+
+import (
+	"context"
+	"datapointbackend/config"
+	"datapointbackend/internal/repository"
+	"datapointbackend/pkg/database"
+	"datapointbackend/pkg/envelope"
+	"flag"
+	"fmt"
+)
+
+func main() {
+	generate := flag.Bool("generate", false, "напечатать новый мастер-ключ")
+	flag.Parse()
+
+	if *generate {
+		key, err := envelope.GenerateKey()
+		if err != nil {
+			panic(err.Error())
+		}
+
+		fmt.Println(key)
+
+		return
+	}
+
+	cfg, err := config.New()
+	if err != nil {
+		panic(err.Error())
+	}
+
+	keys, err := envelope.NewKeyring(cfg.Secrets.MasterKey, cfg.Secrets.PreviousKeys...)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	db, err := database.New(cfg.Database)
+	if err != nil {
+		panic(err.Error())
+	}
+	defer func() { _ = db.Close() }()
+
+	n, err := repository.NewSourceRepository(db, keys).Rotate(context.Background())
+	if err != nil {
+		panic(err.Error())
+	}
+
+	fmt.Printf("перешифровано источников: %d\n", n)
+}
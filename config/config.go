@@ -1,20 +1,54 @@
 package config
 
 import (
+	"datapointbackend/pkg/cache"
 	"datapointbackend/pkg/database"
+	"errors"
 	"gopkg.in/yaml.v3"
 	"os"
+	"strings"
 )
 
 type Config struct {
 	Http     Http            `yaml:"http"`
 	Database database.Config `yaml:"database"`
+	Query    database.Limits `yaml:"query"` //ограничения результата для всех источников.
+	Export   Export          `yaml:"export"`
+	Cache    cache.Config    `yaml:"cache"`
+	Schema   Schema          `yaml:"schema"`
+	Secrets  Secrets         `yaml:"secrets"`
+	Widgets  Widgets         `yaml:"widgets"`
 }
 
 type Http struct {
 	Addr string `yaml:"addr"`
 }
 
+type Export struct {
+	MaxRows uint64 `yaml:"max_rows"` //0 - без ограничений.
+}
+
+type Schema struct {
+	TTL              uint `yaml:"ttl"`               //время хранения сведений о таблицах источников в секундах.
+	SnapshotInterval uint `yaml:"snapshot_interval"` //период снимков схем источников в секундах, 0 - снимки только по запросу.
+}
+
+// Widgets - исполнение запросов виджетов при получении данных виджетов и дашбордов.
+type Widgets struct {
+	Concurrency int  `yaml:"concurrency"` //одновременных запросов к одному источнику.
+	Timeout     uint `yaml:"timeout"`     //время на запрос одного виджета с ожиданием очереди в секундах, 0 - без ограничений.
+}
+
+// Secrets - мастер-ключи шифрования секретов источников длиной 32 байта в base64
+// и то, на что могут ссылаться пароли источников.
+// Переменные окружения DATAPOINT_MASTER_KEY и DATAPOINT_PREVIOUS_MASTER_KEYS (через запятую) важнее файла.
+type Secrets struct {
+	MasterKey    string   `yaml:"master_key"`
+	PreviousKeys []string `yaml:"previous_keys"` //ключи до ротации, нужны только для чтения.
+	EnvPrefix    string   `yaml:"env_prefix"`    //префикс переменных окружения для ссылок env:, по умолчанию DATAPOINT_SOURCE_.
+	Dir          string   `yaml:"dir"`           //каталог файлов для ссылок file:, пусто - ссылки на файлы запрещены.
+}
+
 func New() (*Config, error) {
 	data, err := os.ReadFile("./config/config.yaml")
 	if err != nil {
@@ -23,5 +57,22 @@ func New() (*Config, error) {
 
 	cfg := new(Config)
 
-	return cfg, yaml.Unmarshal(data, cfg)
+	if err = yaml.Unmarshal(data, cfg); err != nil {
+		return nil, err
+	}
+
+	if key := os.Getenv("DATAPOINT_MASTER_KEY"); len(key) != 0 {
+		cfg.Secrets.MasterKey = key
+	}
+
+	if keys := os.Getenv("DATAPOINT_PREVIOUS_MASTER_KEYS"); len(keys) != 0 {
+		cfg.Secrets.PreviousKeys = strings.Split(keys, ",")
+	}
+
+	//без ключа по умолчанию секреты не шифруются ключом, известным всем.
+	if len(cfg.Secrets.MasterKey) == 0 {
+		return nil, errors.New("не задан мастер-ключ: укажите DATAPOINT_MASTER_KEY или secrets.master_key")
+	}
+
+	return cfg, nil
 }
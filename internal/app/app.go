@@ -1,14 +1,21 @@
 package app
 
 import (
+	"context"
 	"datapointbackend/config"
 	"datapointbackend/internal/handler"
 	"datapointbackend/internal/repository"
 	"datapointbackend/internal/service"
+	"datapointbackend/pkg/cache"
 	"datapointbackend/pkg/database"
+	"datapointbackend/pkg/envelope"
+	"datapointbackend/pkg/secret"
+	"errors"
+	"fmt"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	jsoniter "github.com/json-iterator/go"
+	"time"
 )
 
 func Run(cfg *config.Config) error {
@@ -23,25 +30,63 @@ func Run(cfg *config.Config) error {
 		},
 	}))
 
+	//ссылки на пароли ограничиваются до первого подключения.
+	if len(cfg.Secrets.EnvPrefix) != 0 {
+		secret.Register("env", secret.Env{Prefix: cfg.Secrets.EnvPrefix})
+	}
+	secret.Register("file", secret.Files{Dir: cfg.Secrets.Dir})
+
 	db, err := database.New(cfg.Database)
 	if err != nil {
 		return err
 	}
 
+	keys, err := envelope.NewKeyring(cfg.Secrets.MasterKey, cfg.Secrets.PreviousKeys...)
+	if err != nil {
+		return err
+	}
+
+	storage, err := cache.New(cfg.Cache)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = storage.Close() }()
+
 	var (
-		sr = repository.NewSourceRepository(db)
-		wr = repository.NewWidgetRepository(db)
-		dr = repository.NewDashboardRepository(db)
+		sr  = repository.NewSourceRepository(db, keys)
+		wr  = repository.NewWidgetRepository(db)
+		dr  = repository.NewDashboardRepository(db)
+		ar  = repository.NewAuditRepository(db)
+		scr = repository.NewSchemaRepository(db)
 	)
 
+	//запросы виджетов читаются только в типизированном виде.
+	migrated, err := wr.QueriesMigrated(context.Background())
+	if err != nil {
+		return fmt.Errorf("не удалось проверить миграцию запросов виджетов: %s", err.Error())
+	}
+	if !migrated {
+		return errors.New("запросы виджетов не переписаны в типизированном виде, запустите go run ./cmd/migratewidgets")
+	}
+
+	widgetTimeout := time.Duration(cfg.Widgets.Timeout) * time.Second
+
 	var (
-		ss = service.NewSourceService(sr)
-		qs = service.NewQueryService(ss)
-		ws = service.NewWidgetService(wr)
-		ds = service.NewDashboardService(dr)
+		ss  = service.NewSourceService(sr, cfg.Query, storage, time.Duration(cfg.Schema.TTL)*time.Second)
+		as  = service.NewAuditService(ar)
+		qs  = service.NewQueryService(ss, as, cfg.Export.MaxRows)
+		ws  = service.NewWidgetService(wr, ss, qs, cfg.Widgets.Concurrency, widgetTimeout)
+		ds  = service.NewDashboardService(dr, ws)
+		scs = service.NewSchemaService(ss, scr, wr)
 	)
 
-	handler.NewRouter(app, ss, qs, ws, ds)
+	go ss.Run(context.Background())
+
+	if cfg.Schema.SnapshotInterval != 0 {
+		go scs.Run(context.Background(), time.Duration(cfg.Schema.SnapshotInterval)*time.Second)
+	}
+
+	handler.NewRouter(app, ss, qs, ws, ds, as, scs)
 
 	return app.Listen(cfg.Http.Addr)
 }
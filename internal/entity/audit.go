@@ -0,0 +1,49 @@
+package entity
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type Audit struct {
+	Id           string           `json:"id"`
+	SourceId     string           `json:"sourceId"`
+	Type         string           `json:"type"`
+	Table        string           `json:"table"`
+	Query        *json.RawMessage `json:"query"`
+	RawSql       string           `json:"rawSql"`
+	Args         *json.RawMessage `json:"args"`
+	RowsAffected int64            `json:"rowsAffected"`
+	Before       *json.RawMessage `json:"before"` //строки до изменения по значению первичного ключа.
+	After        *json.RawMessage `json:"after"`  //строки после изменения по значению первичного ключа.
+	Caller       Caller           `json:"caller"`
+	Status       string           `json:"status"`
+	CreatedAt    time.Time        `json:"createdAt"`
+}
+
+// статусы записи журнала аудита. Запись сохраняется до фиксации изменения в источнике,
+// поэтому журнал не теряет изменения, а статус показывает, зафиксировано ли оно.
+const (
+	AuditPending    = "pending" //результат фиксации неизвестен, например сервер остановился до ее завершения.
+	AuditCommitted  = "committed"
+	AuditRolledBack = "rolledBack"
+)
+
+// Caller - тот, кто исполняет запрос. Аутентификации нет, поэтому достоверен только адрес.
+type Caller struct {
+	Addr string `json:"addr"` //адрес клиента.
+	User string `json:"user"` //имя из заголовка X-User, указывается клиентом и не проверяется.
+}
+
+type AuditFilter struct {
+	SourceId string `query:"sourceId"`
+	Type     string `query:"type"`
+	Table    string `query:"table"`
+	Caller   string `query:"caller"` //адрес клиента.
+	User     string `query:"user"`   //имя из заголовка X-User.
+	Status   string `query:"status"`
+	From     string `query:"from"` //RFC 3339.
+	To       string `query:"to"`   //RFC 3339.
+	Limit    uint64 `query:"limit"`
+	Offset   uint64 `query:"offset"`
+}
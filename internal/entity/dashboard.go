@@ -1,15 +1,66 @@
 package entity
 
+import "datapointbackend/pkg/database"
+
 type DashboardWidget struct {
 	*Widget
-	X uint `json:"x"`
-	Y uint `json:"y"`
-	W uint `json:"w"`
-	H uint `json:"h"`
+	X        uint            `json:"x"`
+	Y        uint            `json:"y"`
+	W        uint            `json:"w"`
+	H        uint            `json:"h"`
+	Bindings []FilterBinding `json:"bindings"` //столбцы запроса виджета, к которым применяются фильтры дашборда.
+
+	//выбор значений на виджете фильтрует другие виджеты дашборда.
+	Emits    []string           `json:"emits"`    //metaKey правил результата, значения которых можно выбрать на виджете.
+	Consumes []SelectionBinding `json:"consumes"` //столбцы запроса виджета, к которым применяется выбор на других виджетах.
 }
 
 type Dashboard struct {
 	Id      string             `json:"id"`
 	Name    string             `json:"name"`
+	Filters []DashboardFilter  `json:"filters"`
 	Widgets []*DashboardWidget `json:"widgets"`
 }
+
+// типы фильтров дашборда.
+const (
+	FilterDateRange = "dateRange" //значение - массив [от, до], null - граница не ограничена.
+	FilterDimension = "dimension" //значение или массив значений.
+	FilterSearch    = "search"    //подстрока без учета регистра.
+)
+
+// DashboardFilter - общий фильтр дашборда, который применяется к виджетам через привязки.
+type DashboardFilter struct {
+	Id      string `json:"id"` //задается клиентом, уникален в пределах дашборда.
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Default any    `json:"default"` //значение, если фильтр не выбран.
+}
+
+// FilterBinding связывает фильтр дашборда со столбцом запроса виджета.
+type FilterBinding struct {
+	FilterId string             `json:"filterId"`
+	TableKey database.QTableKey `json:"tableKey"`
+	Column   string             `json:"column"`
+}
+
+// SelectionBinding связывает выбор на другом виджете со столбцом запроса виджета.
+type SelectionBinding struct {
+	WidgetId string             `json:"widgetId"` //виджет, на котором выбираются значения.
+	MetaKey  string             `json:"metaKey"`
+	TableKey database.QTableKey `json:"tableKey"`
+	Column   string             `json:"column"`
+}
+
+// Selection - значения, выбранные на виджете, например столбец диаграммы.
+type Selection struct {
+	WidgetId string `json:"widgetId"`
+	MetaKey  string `json:"metaKey"`
+	Values   []any  `json:"values"`
+}
+
+// DashboardInput - выбранные значения фильтров дашборда по идентификаторам фильтров и выбор на виджетах.
+type DashboardInput struct {
+	Filters    map[string]any `json:"filters"`
+	Selections []Selection    `json:"selections"`
+}
@@ -1,8 +1,22 @@
 package entity
 
-import "datapointbackend/pkg/database"
+import (
+	"datapointbackend/pkg/database"
+	"time"
+)
 
 type Query struct {
-	SourceId string `json:"sourceId"`
+	SourceId string         `json:"sourceId"`
+	Values   map[string]any `json:"values"` //значения параметров запроса при исполнении, не сохраняются.
 	database.Query
 }
+
+// RunningQuery - запрос, который исполняется в данный момент.
+type RunningQuery struct {
+	Id        string    `json:"id"`
+	SourceId  string    `json:"sourceId"`
+	Type      string    `json:"type"`
+	Table     string    `json:"table"`
+	Caller    Caller    `json:"caller"`
+	StartedAt time.Time `json:"startedAt"`
+}
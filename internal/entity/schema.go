@@ -0,0 +1,47 @@
+package entity
+
+import (
+	"datapointbackend/pkg/database"
+	"time"
+)
+
+// SchemaSnapshot - таблицы источника на момент времени.
+type SchemaSnapshot struct {
+	Id        string            `json:"id"`
+	SourceId  string            `json:"sourceId"`
+	Tables    []*database.Table `json:"tables"`
+	CreatedAt time.Time         `json:"createdAt"`
+}
+
+// виды изменений схемы.
+const (
+	TableAdded    = "tableAdded"
+	TableRemoved  = "tableRemoved"
+	ColumnAdded   = "columnAdded"
+	ColumnRemoved = "columnRemoved"
+	ColumnRetyped = "columnRetyped"
+)
+
+type SchemaChange struct {
+	Kind    string `json:"kind"`
+	Table   string `json:"table"`
+	Column  string `json:"column"`  //пустой для изменений таблиц.
+	OldType string `json:"oldType"` //тип в базе данных, только для columnRemoved и columnRetyped.
+	NewType string `json:"newType"` //тип в базе данных, только для columnAdded и columnRetyped.
+}
+
+// WidgetImpact - виджет, запрос которого ссылается на удаленные или измененные таблицы и столбцы.
+type WidgetImpact struct {
+	WidgetId   string         `json:"widgetId"`
+	WidgetName string         `json:"widgetName"`
+	Changes    []SchemaChange `json:"changes"`
+}
+
+// SchemaChanges - изменения схемы источника между двумя снимками и их влияние на виджеты.
+type SchemaChanges struct {
+	SourceId string         `json:"sourceId"`
+	From     *time.Time     `json:"from"` //null, если более раннего снимка нет.
+	To       time.Time      `json:"to"`
+	Changes  []SchemaChange `json:"changes"`
+	Impact   []WidgetImpact `json:"impact"`
+}
@@ -1,10 +1,63 @@
 package entity
 
-import "datapointbackend/pkg/database"
+import (
+	"datapointbackend/pkg/database"
+	"time"
+)
+
+// состояния подключения к источнику.
+const (
+	SourceIdle       = "idle"       //подключение еще не требовалось.
+	SourceConnecting = "connecting" //подключение в процессе.
+	SourceConnected  = "connected"
+	SourceFailed     = "failed" //подключиться не удалось, будет повторная попытка.
+)
+
+// SecretMask заменяет секреты источника в ответах, а в запросе на изменение означает, что секрет не меняется.
+const SecretMask = "********"
 
 type Source struct {
-	Id        string `json:"id"`
-	Name      string `json:"name"`
-	Connected bool   `json:"connected"`
+	Id                string     `json:"id"`
+	Name              string     `json:"name"`
+	Connected         bool       `json:"connected"`
+	State             string     `json:"state"`
+	LastError         string     `json:"lastError"`         //ошибка последней попытки подключения.
+	SchemaRefreshedAt *time.Time `json:"schemaRefreshedAt"` //время последнего чтения таблиц, null - таблицы еще не прочитаны.
+	Tunnel            *Tunnel    `json:"tunnel"`            //null - подключение напрямую.
 	database.Config
 }
+
+// Tunnel - SSH-туннель через промежуточный хост, за которым находится источник.
+// Нужен пароль или закрытый ключ в формате PEM, ключ хоста проверяется по KnownHosts.
+type Tunnel struct {
+	Host       string `json:"host"`
+	Port       int    `json:"port"` //по умолчанию 22.
+	User       string `json:"user"`
+	Password   string `json:"password"`
+	PrivateKey string `json:"privateKey"`
+	Passphrase string `json:"passphrase"` //пароль закрытого ключа.
+	KnownHosts string `json:"knownHosts"` //в формате файла known_hosts.
+}
+
+// Secrets возвращает секретные поля источника, порядок полей не зависит от источника.
+func (s *Source) Secrets() []*string {
+	secrets := []*string{&s.Password, &s.SSLKey}
+	if s.Tunnel != nil {
+		secrets = append(secrets, &s.Tunnel.Password, &s.Tunnel.PrivateKey, &s.Tunnel.Passphrase)
+	}
+	return secrets
+}
+
+// Redact заменяет непустые секреты на SecretMask.
+func (s *Source) Redact() {
+	if s.Tunnel != nil {
+		t := *s.Tunnel
+		s.Tunnel = &t
+	}
+
+	for _, secret := range s.Secrets() {
+		if len(*secret) != 0 {
+			*secret = SecretMask
+		}
+	}
+}
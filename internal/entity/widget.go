@@ -1,13 +1,33 @@
 package entity
 
-import "encoding/json"
+import (
+	"datapointbackend/pkg/database"
+	"encoding/json"
+)
 
 type Widget struct {
-	Id       string           `json:"id"`
-	Name     string           `json:"name"`
-	Type     string           `json:"type"`
-	Children []*Widget        `json:"children"`
-	Props    *json.RawMessage `json:"props"`
-	//чтобы ускорить разработку пока так, а дальше посмотрим :/.
-	Query *json.RawMessage `json:"query"`
+	Id         string           `json:"id"`
+	Name       string           `json:"name"`
+	Type       string           `json:"type"`
+	Children   []*Widget        `json:"children"`
+	Props      *json.RawMessage `json:"props"`
+	Query      *Query           `json:"query"`      //null - виджет без данных.
+	QueryError string           `json:"queryError"` //сохраненный запрос не удалось прочитать, его нужно сохранить заново.
+}
+
+// WidgetInput - изменения сохраненного запроса виджета, которые допустимы при получении его данных.
+type WidgetInput struct {
+	//дополнительные условия where, применяются только к запросам, в которых есть таблица условия.
+	Filters  []*database.QColumn `json:"filters"`
+	Limit    *uint64             `json:"limit"`
+	Offset   *uint64             `json:"offset"`
+	Cursor   string              `json:"cursor"`
+	TimeZone string              `json:"timeZone"`
+	Values   map[string]any      `json:"values"` //значения параметров запроса.
+}
+
+// WidgetData - результат запроса виджета.
+type WidgetData struct {
+	WidgetId string `json:"widgetId"`
+	database.QResponse
 }
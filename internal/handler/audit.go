@@ -0,0 +1,54 @@
+package handler
+
+import (
+	"datapointbackend/internal/entity"
+	"datapointbackend/internal/service"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"time"
+)
+
+type auditHandler struct {
+	as *service.AuditService
+}
+
+func newAuditHandler(app *fiber.App, as *service.AuditService) {
+	h := auditHandler{as: as}
+	g := app.Group("/audit")
+	g.Get("/", h.getAll)
+}
+
+// @tags		аудит
+// @param		sourceId	query	string	false	"идентификатор источника"
+// @param		type		query	string	false	"тип команды"
+// @param		table		query	string	false	"таблица"
+// @param		caller		query	string	false	"адрес клиента, исполнившего запрос"
+// @param		user		query	string	false	"имя из заголовка X-User, не проверяется"
+// @param		status		query	string	false	"статус записи: pending, committed или rolledBack"
+// @param		from		query	string	false	"начало периода (RFC 3339)"
+// @param		to			query	string	false	"конец периода (RFC 3339)"
+// @param		limit		query	int		false	"количество записей"
+// @param		offset		query	int		false	"смещение"
+// @success	200	{array}	entity.Audit
+// @router		/audit [get]
+func (h *auditHandler) getAll(ctx *fiber.Ctx) error {
+	var filter entity.AuditFilter
+
+	err := ctx.QueryParser(&filter)
+	if err != nil {
+		return err
+	}
+
+	for _, t := range []string{filter.From, filter.To} {
+		if _, err = time.Parse(time.RFC3339, t); len(t) != 0 && err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("неверная граница периода %s, ожидается RFC 3339", t))
+		}
+	}
+
+	sl, err := h.as.GetAll(ctx.Context(), filter)
+	if err != nil {
+		return err
+	}
+
+	return ctx.JSON(sl)
+}
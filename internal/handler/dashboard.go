@@ -1,8 +1,12 @@
 package handler
 
 import (
+	"bufio"
+	"context"
 	"datapointbackend/internal/entity"
 	"datapointbackend/internal/service"
+	"encoding/json"
+	"fmt"
 	"github.com/gofiber/fiber/v2"
 )
 
@@ -15,6 +19,9 @@ func newDashboardHandler(app *fiber.App, ds *service.DashboardService) {
 	g := app.Group("/dashboards")
 	g.Get("/", h.getAll)
 	g.Get("/:id", h.getOne)
+	g.Get("/:id/data", h.getData)
+	g.Post("/:id/data", h.getData)
+	g.Get("/:id/data/stream", h.streamData)
 	g.Post("/", h.create)
 	g.Patch("/", h.edit)
 	g.Delete("/:id", h.delete)
@@ -74,3 +81,115 @@ func (h *dashboardHandler) edit(ctx *fiber.Ctx) error {
 
 	return nil
 }
+
+// getData - в GET выбранные значения фильтров и выбор на виджетах передаются параметрами filters
+// и selections в виде JSON, в POST - телом entity.DashboardInput.
+//
+// @tags		дашборды
+// @param		id			path		string					true	"идентификатор дашборда"
+// @param		input		body		entity.DashboardInput	false	"выбранные значения фильтров и выбор на виджетах"
+// @param		filters		query		string					false	"выбранные значения фильтров в виде JSON"
+// @param		selections	query		string					false	"выбор на виджетах в виде JSON"
+// @success	200			{object}	map[string]database.QResponse
+// @router		/dashboards/{id}/data [get]
+// @router		/dashboards/{id}/data [post]
+func (h *dashboardHandler) getData(ctx *fiber.Ctx) error {
+	input, err := dashboardInput(ctx)
+	if err != nil {
+		return err
+	}
+
+	data, err := h.ds.GetData(service.WithCaller(ctx.Context(), caller(ctx)), ctx.Params("id"), input)
+	if err != nil {
+		return err
+	}
+
+	return ctx.JSON(data)
+}
+
+// streamData отдает результаты виджетов событиями SSE widget по мере готовности и событие done в конце.
+//
+// @tags		дашборды
+// @param		id			path	string	true	"идентификатор дашборда"
+// @param		filters		query	string	false	"выбранные значения фильтров в виде JSON"
+// @param		selections	query	string	false	"выбор на виджетах в виде JSON"
+// @produce	text/event-stream
+// @success	200	{object}	entity.WidgetData
+// @router		/dashboards/{id}/data/stream [get]
+func (h *dashboardHandler) streamData(ctx *fiber.Ctx) error {
+	input, err := dashboardInput(ctx)
+	if err != nil {
+		return err
+	}
+
+	d, err := h.ds.GetOne(ctx.Context(), ctx.Params("id"))
+	if err != nil {
+		return err
+	}
+
+	inputs, err := h.ds.Inputs(d, input)
+	if err != nil {
+		return err
+	}
+
+	ctx.Set(fiber.HeaderContentType, "text/event-stream")
+	ctx.Set(fiber.HeaderCacheControl, "no-cache")
+	ctx.Set(fiber.HeaderConnection, "keep-alive")
+
+	//события пишутся уже после выхода из обработчика, поэтому контекст запроса не используется.
+	c, cancel := context.WithCancel(service.WithCaller(context.Background(), caller(ctx)))
+
+	ctx.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer cancel()
+
+		h.ds.Render(c, d, inputs, func(wd entity.WidgetData) {
+			//клиент отключился, оставшиеся запросы отменяются.
+			if err := writeEvent(w, "widget", wd); err != nil {
+				cancel()
+			}
+		})
+
+		_ = writeEvent(w, "done", struct{}{})
+	})
+
+	return nil
+}
+
+func writeEvent(w *bufio.Writer, event string, data any) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	if _, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, b); err != nil {
+		return err
+	}
+
+	return w.Flush()
+}
+
+func dashboardInput(ctx *fiber.Ctx) (entity.DashboardInput, error) {
+	var input entity.DashboardInput
+
+	if ctx.Method() == fiber.MethodPost {
+		if len(ctx.Body()) == 0 {
+			return input, nil
+		}
+
+		return input, ctx.BodyParser(&input)
+	}
+
+	if filters := ctx.Query("filters"); len(filters) != 0 {
+		if err := json.Unmarshal([]byte(filters), &input.Filters); err != nil {
+			return input, fmt.Errorf("неверные значения фильтров: %s", err.Error())
+		}
+	}
+
+	if selections := ctx.Query("selections"); len(selections) != 0 {
+		if err := json.Unmarshal([]byte(selections), &input.Selections); err != nil {
+			return input, fmt.Errorf("неверный выбор на виджетах: %s", err.Error())
+		}
+	}
+
+	return input, nil
+}
@@ -1,8 +1,14 @@
 package handler
 
 import (
+	"bufio"
+	"context"
 	"datapointbackend/internal/entity"
 	"datapointbackend/internal/service"
+	"datapointbackend/pkg/database"
+	"datapointbackend/pkg/export"
+	"errors"
+	"fmt"
 	"github.com/gofiber/fiber/v2"
 )
 
@@ -14,11 +20,17 @@ func newQueryHandler(app *fiber.App, qs *service.QueryService) {
 	h := queryHandler{qs: qs}
 	group := app.Group("/queries")
 	group.Post("/execute", h.execute)
+	group.Post("/export", h.export)
+	group.Get("/running", h.getRunning)
+	group.Delete("/running/:id", h.cancel)
+	group.Delete("/cache", h.purgeCache)
+	group.Get("/cache/stats", h.getCacheStats)
 }
 
-// @tags	запросы
-// @param	query	body	entity.Query	true	"запрос"
-// @router	/queries/execute [post]
+// @tags		запросы
+// @param		query	body	entity.Query	true	"запрос"
+// @produce	json,application/vnd.apache.arrow.stream
+// @router		/queries/execute [post]
 func (h *queryHandler) execute(ctx *fiber.Ctx) error {
 	var query entity.Query
 
@@ -27,5 +39,165 @@ func (h *queryHandler) execute(ctx *fiber.Ctx) error {
 		return err
 	}
 
-	return ctx.JSON(h.qs.Execute(ctx.Context(), query))
+	if query.Type == database.Select && ctx.Accepts(fiber.MIMEApplicationJSON, database.ArrowStream) == database.ArrowStream {
+		return h.executeArrow(ctx, query)
+	}
+
+	return ctx.JSON(h.qs.Execute(service.WithCaller(ctx.Context(), caller(ctx)), query))
+}
+
+// executeArrow отдает результат select потоком пакетов Arrow IPC по мере чтения строк. Как и выгрузка,
+// запрос исполняется без ограничений ответа JSON и кэша, но с ограничением строк выгрузки
+// и числится среди исполняемых запросов. Ошибка исполнения запроса возвращается статусом ответа,
+// а ошибка посреди потока обрывает соединение: Arrow IPC не может сообщить о ней.
+func (h *queryHandler) executeArrow(ctx *fiber.Ctx, query entity.Query) error {
+	//строки читаются уже после выхода из обработчика, поэтому контекст запроса не используется.
+	rows, err := h.qs.Export(service.WithCaller(context.Background(), caller(ctx)), query)
+	if err != nil {
+		return err
+	}
+
+	ctx.Set(fiber.HeaderContentType, database.ArrowStream)
+
+	conn := ctx.Context().Conn()
+
+	ctx.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer func() { _ = rows.Close() }()
+
+		//каждый пакет уходит клиенту, как только прочитан.
+		if err := database.WriteArrow(w, rows.Columns, rows, query.TimeZone, database.DefaultArrowBatch); err != nil {
+			_ = conn.Close()
+			return
+		}
+
+		_ = w.Flush()
+	})
+
+	return nil
+}
+
+// export выгружает результат select потоком. Если выгрузку прервала ошибка, ndjson заканчивается
+// строкой {"$error": "..."}, а csv и xlsx обрываются вместе с соединением.
+//
+// @tags		запросы
+// @param		query	body	entity.Query	true	"запрос"
+// @param		format	query	string			false	"формат выгрузки: csv, ndjson или xlsx"
+// @router		/queries/export [post]
+func (h *queryHandler) export(ctx *fiber.Ctx) error {
+	var query entity.Query
+
+	err := ctx.BodyParser(&query)
+	if err != nil {
+		return err
+	}
+
+	format := ctx.Query("format", export.CSV)
+	if !export.Supported(format) {
+		return fmt.Errorf("неизвестный формат выгрузки %s", format)
+	}
+
+	//строки читаются уже после выхода из обработчика, поэтому контекст запроса не используется,
+	//а отменить выгрузку можно через список исполняемых запросов.
+	var rows *service.Rows
+
+	if rows, err = h.qs.Export(service.WithCaller(context.Background(), caller(ctx)), query); err != nil {
+		return err
+	}
+
+	ctx.Attachment("export." + format)
+	ctx.Set(fiber.HeaderContentType, export.ContentType(format))
+
+	//статус уже отправлен, поэтому об ошибке посреди выгрузки можно сообщить только в самом файле
+	//или обрывом соединения, иначе клиент примет оборванный файл за законченный.
+	conn := ctx.Context().Conn()
+
+	ctx.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer func() { _ = rows.Close() }()
+
+		ew, err := export.New(format, w)
+		if err != nil {
+			_ = conn.Close()
+			return
+		}
+
+		if err = writeExport(ew, rows); err != nil {
+			if ew.Abort(err) != nil {
+				_ = conn.Close()
+				return
+			}
+		}
+
+		_ = w.Flush()
+	})
+
+	return nil
+}
+
+func writeExport(ew export.Writer, rows *service.Rows) error {
+	if err := ew.WriteHeader(rows.Columns); err != nil {
+		return err
+	}
+
+	for rows.Next() {
+		values, err := rows.Values()
+		if err != nil {
+			return err
+		}
+
+		if err = ew.WriteRow(values); err != nil {
+			return err
+		}
+	}
+
+	//ошибка или истечение времени запроса посреди строк.
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("не удалось исполнить запрос: %s", err.Error())
+	}
+
+	return ew.Close()
+}
+
+// @tags		запросы
+// @success	200	{array}	entity.RunningQuery
+// @router		/queries/running [get]
+func (h *queryHandler) getRunning(ctx *fiber.Ctx) error {
+	return ctx.JSON(h.qs.GetRunning())
+}
+
+// @tags	запросы
+// @param	id	path	string	true	"идентификатор исполняемого запроса"
+// @router	/queries/running/{id} [delete]
+func (h *queryHandler) cancel(ctx *fiber.Ctx) error {
+	if err := h.qs.Cancel(ctx.Params("id")); err != nil {
+		if errors.Is(err, service.ErrNotRunning) {
+			return fiber.ErrNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// @tags		запросы
+// @param		sourceId	query		string	false	"идентификатор источника, если не указан, кэш очищается полностью"
+// @success	200			{integer}	int		"количество удаленных результатов"
+// @router		/queries/cache [delete]
+func (h *queryHandler) purgeCache(ctx *fiber.Ctx) error {
+	count, err := h.qs.PurgeCache(ctx.Context(), ctx.Query("sourceId"))
+	if err != nil {
+		return err
+	}
+
+	return ctx.JSON(count)
+}
+
+// @tags		запросы
+// @success	200	{object}	cache.Stats
+// @router		/queries/cache/stats [get]
+func (h *queryHandler) getCacheStats(ctx *fiber.Ctx) error {
+	return ctx.JSON(h.qs.CacheStats())
+}
+
+// caller - тот, кто исполняет запрос: IP-адрес и непроверенное имя из заголовка X-User.
+func caller(ctx *fiber.Ctx) entity.Caller {
+	return entity.Caller{Addr: ctx.IP(), User: ctx.Get("X-User")}
 }
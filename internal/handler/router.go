@@ -13,10 +13,14 @@ func NewRouter(
 	qs *service.QueryService,
 	ws *service.WidgetService,
 	ds *service.DashboardService,
+	as *service.AuditService,
+	scs *service.SchemaService,
 ) {
 	app.Get("/swagger/*", swagger.HandlerDefault)
 	newSourceHandler(app, ss)
 	newQueryHandler(app, qs)
 	newWidgetHandler(app, ws)
 	newDashboardHandler(app, ds)
+	newAuditHandler(app, as)
+	newSchemaHandler(app, scs)
 }
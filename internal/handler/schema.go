@@ -0,0 +1,42 @@
+package handler
+
+import (
+	"datapointbackend/internal/service"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"time"
+)
+
+type schemaHandler struct {
+	scs *service.SchemaService
+}
+
+func newSchemaHandler(app *fiber.App, scs *service.SchemaService) {
+	h := schemaHandler{scs: scs}
+	g := app.Group("/sources")
+	g.Get("/:id/schema-changes", h.getChanges)
+}
+
+// @tags		источники
+// @param		id		path		string	true	"идентификатор источника"
+// @param		since	query		string	false	"сравнить со снимком на этот момент (RFC 3339), по умолчанию с предыдущим снимком"
+// @success	200		{object}	entity.SchemaChanges
+// @router		/sources/{id}/schema-changes [get]
+func (h *schemaHandler) getChanges(ctx *fiber.Ctx) error {
+	var since *time.Time
+
+	if value := ctx.Query("since"); len(value) != 0 {
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return fmt.Errorf("не удалось разобрать since: %s", err.Error())
+		}
+		since = &t
+	}
+
+	changes, err := h.scs.GetChanges(ctx.Context(), ctx.Params("id"), since)
+	if err != nil {
+		return err
+	}
+
+	return ctx.JSON(changes)
+}
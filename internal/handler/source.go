@@ -1,9 +1,11 @@
 package handler
 
 import (
+	"context"
 	"datapointbackend/internal/entity"
 	"datapointbackend/internal/service"
 	"github.com/gofiber/fiber/v2"
+	"time"
 )
 
 type sourceHandler struct {
@@ -15,12 +17,16 @@ func newSourceHandler(app *fiber.App, ss *service.SourceService) {
 	g := app.Group("/sources")
 	g.Get("/", h.getAll)
 	g.Get("/drivers", h.getDrivers)
+	g.Get("/schema-cache", h.getSchemaCacheStats)
 	g.Get("/:id", h.getOne)
 	g.Get("/:id/tables", h.getTables)
 	g.Post("/", h.create)
+	g.Post("/test", h.test)
 	g.Patch("/", h.edit)
 	g.Delete("/:id", h.delete)
 	g.Get("/:id/functions", h.getFunctions)
+	g.Post("/:id/refresh-schema", h.refreshSchema)
+	g.Get("/:id/stats", h.getStats)
 }
 
 // @tags		источники
@@ -120,3 +126,57 @@ func (h *sourceHandler) getFunctions(ctx *fiber.Ctx) error {
 	}
 	return ctx.JSON(functions)
 }
+
+// @tags		источники
+// @param		id	path		string	true	"идентификатор источника"
+// @success	200	{string}	string	"время чтения таблиц"
+// @router		/sources/{id}/refresh-schema [post]
+func (h *sourceHandler) refreshSchema(ctx *fiber.Ctx) error {
+	refreshedAt, err := h.ss.RefreshSchema(ctx.Context(), ctx.Params("id"))
+	if err != nil {
+		return err
+	}
+
+	return ctx.JSON(refreshedAt)
+}
+
+// @tags		источники
+// @success	200	{object}	cache.Stats
+// @router		/sources/schema-cache [get]
+func (h *sourceHandler) getSchemaCacheStats(ctx *fiber.Ctx) error {
+	return ctx.JSON(h.ss.SchemaCacheStats())
+}
+
+// @tags		источники
+// @param		id	path		string	true	"идентификатор источника"
+// @success	200	{object}	database.Stats
+// @router		/sources/{id}/stats [get]
+func (h *sourceHandler) getStats(ctx *fiber.Ctx) error {
+	stats, err := h.ss.GetStats(ctx.Params("id"))
+	if err != nil {
+		return err
+	}
+
+	return ctx.JSON(stats)
+}
+
+// testTimeout - общее время на все шаги проверки подключения.
+const testTimeout = 15 * time.Second
+
+// @tags		источники
+// @param		source	body		entity.Source	true	"источник"
+// @success	200		{object}	database.Diagnosis
+// @router		/sources/test [post]
+func (h *sourceHandler) test(ctx *fiber.Ctx) error {
+	var source entity.Source
+
+	err := ctx.BodyParser(&source)
+	if err != nil {
+		return err
+	}
+
+	c, cancel := context.WithTimeout(ctx.Context(), testTimeout)
+	defer cancel()
+
+	return ctx.JSON(h.ss.Test(c, source))
+}
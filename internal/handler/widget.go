@@ -3,7 +3,11 @@ package handler
 import (
 	"datapointbackend/internal/entity"
 	"datapointbackend/internal/service"
+	"encoding/json"
+	"fmt"
 	"github.com/gofiber/fiber/v2"
+	"strconv"
+	"strings"
 )
 
 type widgetHandler struct {
@@ -15,6 +19,8 @@ func newWidgetHandler(app *fiber.App, ws *service.WidgetService) {
 	g := app.Group("/widgets")
 	g.Get("/", h.getAll)
 	g.Get("/:id", h.getOne)
+	g.Get("/:id/data", h.getData)
+	g.Post("/:id/data", h.getData)
 	g.Delete("/:id", h.delete)
 	g.Post("/", h.create)
 	g.Patch("/", h.edit)
@@ -82,3 +88,78 @@ func (h *widgetHandler) edit(ctx *fiber.Ctx) error {
 
 	return nil
 }
+
+// getData - в GET изменения запроса передаются параметрами строки запроса, фильтры и значения параметров в виде JSON,
+// в POST - телом entity.WidgetInput.
+//
+// @tags		виджеты
+// @param		id			path		string				true	"идентификатор виджета"
+// @param		input		body		entity.WidgetInput	false	"изменения запроса"
+// @param		filters		query		string				false	"фильтры в виде JSON"
+// @param		values		query		string				false	"значения параметров запроса в виде JSON"
+// @param		limit		query		int					false	"количество строк"
+// @param		offset		query		int					false	"смещение"
+// @param		cursor		query		string				false	"курсор следующей страницы"
+// @param		timeZone	query		string				false	"часовой пояс"
+// @success	200			{object}	map[string]database.QResponse
+// @router		/widgets/{id}/data [get]
+// @router		/widgets/{id}/data [post]
+func (h *widgetHandler) getData(ctx *fiber.Ctx) error {
+	input, err := widgetInput(ctx)
+	if err != nil {
+		return err
+	}
+
+	data, err := h.ws.GetData(service.WithCaller(ctx.Context(), caller(ctx)), ctx.Params("id"), input)
+	if err != nil {
+		return err
+	}
+
+	return ctx.JSON(data)
+}
+
+func widgetInput(ctx *fiber.Ctx) (entity.WidgetInput, error) {
+	var input entity.WidgetInput
+
+	if ctx.Method() == fiber.MethodPost {
+		if len(ctx.Body()) == 0 {
+			return input, nil
+		}
+
+		return input, ctx.BodyParser(&input)
+	}
+
+	if filters := ctx.Query("filters"); len(filters) != 0 {
+		if err := json.Unmarshal([]byte(filters), &input.Filters); err != nil {
+			return input, fmt.Errorf("неверные фильтры: %s", err.Error())
+		}
+	}
+
+	if values := ctx.Query("values"); len(values) != 0 {
+		//числа остаются строками, чтобы не потерять точность больших целых.
+		d := json.NewDecoder(strings.NewReader(values))
+		d.UseNumber()
+
+		if err := d.Decode(&input.Values); err != nil {
+			return input, fmt.Errorf("неверные значения параметров: %s", err.Error())
+		}
+	}
+
+	for name, dest := range map[string]**uint64{"limit": &input.Limit, "offset": &input.Offset} {
+		value := ctx.Query(name)
+		if len(value) == 0 {
+			continue
+		}
+
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return input, fmt.Errorf("неверное значение %s: %s", name, value)
+		}
+
+		*dest = &n
+	}
+
+	input.Cursor, input.TimeZone = ctx.Query("cursor"), ctx.Query("timeZone")
+
+	return input, nil
+}
@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"datapointbackend/internal/entity"
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// parseInput возвращает изменения запроса виджета, разобранные из запроса req.
+func parseInput(t *testing.T, method, query, body string) (entity.WidgetInput, int) {
+	t.Helper()
+
+	var input entity.WidgetInput
+
+	app := fiber.New()
+	app.All("/", func(ctx *fiber.Ctx) error {
+		var err error
+		if input, err = widgetInput(ctx); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+		return nil
+	})
+
+	req := httptest.NewRequest(method, "/?"+query, strings.NewReader(body))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return input, resp.StatusCode
+}
+
+func TestWidgetInputValues(t *testing.T) {
+	query := url.Values{
+		"values": {`{"from": "2024-01-01", "ids": [1, 2], "id": 9007199254740993}`},
+		"limit":  {"10"},
+	}.Encode()
+
+	input, status := parseInput(t, fiber.MethodGet, query, "")
+	if status != fiber.StatusOK {
+		t.Fatalf("status = %d", status)
+	}
+
+	if input.Values["from"] != "2024-01-01" || len(input.Values["ids"].([]any)) != 2 {
+		t.Errorf("values = %v", input.Values)
+	}
+
+	if input.Values["id"] != json.Number("9007199254740993") {
+		t.Errorf("id = %#v, want exact number", input.Values["id"])
+	}
+
+	if input.Limit == nil || *input.Limit != 10 {
+		t.Errorf("limit = %v", input.Limit)
+	}
+
+	//в POST значения приходят в теле.
+	if input, _ = parseInput(t, fiber.MethodPost, "", `{"values": {"from": "2024-02-01"}}`); input.Values["from"] != "2024-02-01" {
+		t.Errorf("POST values = %v", input.Values)
+	}
+
+	if _, status = parseInput(t, fiber.MethodGet, url.Values{"values": {"{"}}.Encode(), ""); status != fiber.StatusBadRequest {
+		t.Errorf("invalid values status = %d, want 400", status)
+	}
+}
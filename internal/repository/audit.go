@@ -0,0 +1,109 @@
+package repository
+
+import (
+	"context"
+	"datapointbackend/internal/entity"
+	"datapointbackend/pkg/database"
+	sq "github.com/Masterminds/squirrel"
+)
+
+type AuditRepository struct {
+	db *database.Database
+}
+
+func NewAuditRepository(db *database.Database) *AuditRepository {
+	return &AuditRepository{db: db}
+}
+
+func (r *AuditRepository) GetAll(ctx context.Context, f entity.AuditFilter) ([]entity.Audit, error) {
+	b := r.db.Builder.
+		Select(
+			"id", "source_id", "type", "table_name", "query", "raw_sql", "args",
+			"rows_affected", "before", "after", "caller", "caller_user", "status", "created_at",
+		).
+		From("audit").
+		OrderBy("created_at DESC").
+		Limit(f.Limit).
+		Offset(f.Offset)
+
+	if len(f.SourceId) != 0 {
+		b = b.Where(sq.Eq{"source_id": f.SourceId})
+	}
+
+	if len(f.Type) != 0 {
+		b = b.Where(sq.Eq{"type": f.Type})
+	}
+
+	if len(f.Table) != 0 {
+		b = b.Where(sq.Eq{"table_name": f.Table})
+	}
+
+	if len(f.Caller) != 0 {
+		b = b.Where(sq.Eq{"caller": f.Caller})
+	}
+
+	if len(f.User) != 0 {
+		b = b.Where(sq.Eq{"caller_user": f.User})
+	}
+
+	if len(f.Status) != 0 {
+		b = b.Where(sq.Eq{"status": f.Status})
+	}
+
+	if len(f.From) != 0 {
+		b = b.Where(sq.GtOrEq{"created_at": f.From})
+	}
+
+	if len(f.To) != 0 {
+		b = b.Where(sq.Lt{"created_at": f.To})
+	}
+
+	rows, err := b.QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var sl []entity.Audit
+	for rows.Next() {
+		var a entity.Audit
+		if err = rows.Scan(
+			&a.Id, &a.SourceId, &a.Type, &a.Table, &a.Query, &a.RawSql, &a.Args,
+			&a.RowsAffected, &a.Before, &a.After, &a.Caller.Addr, &a.Caller.User, &a.Status, &a.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		sl = append(sl, a)
+	}
+
+	return sl, nil
+}
+
+func (r *AuditRepository) Create(ctx context.Context, a entity.Audit) (string, error) {
+	var id string
+
+	err := r.db.Builder.
+		Insert("audit").
+		Columns(
+			"source_id", "type", "table_name", "query", "raw_sql", "args",
+			"rows_affected", "before", "after", "caller", "caller_user", "status",
+		).
+		Values(
+			a.SourceId, a.Type, a.Table, a.Query, a.RawSql, a.Args,
+			a.RowsAffected, a.Before, a.After, a.Caller.Addr, a.Caller.User, a.Status,
+		).
+		Suffix("RETURNING id").
+		QueryRowContext(ctx).
+		Scan(&id)
+
+	return id, err
+}
+
+func (r *AuditRepository) SetStatus(ctx context.Context, id string, status string) error {
+	_, err := r.db.Builder.
+		Update("audit").
+		Set("status", status).
+		Where(sq.Eq{"id": id}).
+		ExecContext(ctx)
+	return err
+}
@@ -40,9 +40,9 @@ func (r *DashboardRepository) GetAll(ctx context.Context) ([]entity.Dashboard, e
 		)
 
 		if err = rows.Scan(
-			&d.Id, &d.Name,
-			&w.Id, &w.Name, &w.Type, &parentId, &w.Props, &w.Query,
-			&w.X, &w.Y, &w.W, &w.H,
+			&d.Id, &d.Name, jsonb{&d.Filters},
+			&w.Id, &w.Name, &w.Type, &parentId, &w.Props, widgetQuery{w.Widget},
+			&w.X, &w.Y, &w.W, &w.H, jsonb{&w.Bindings}, jsonb{&w.Emits}, jsonb{&w.Consumes},
 		); err != nil {
 			return nil, err
 		}
@@ -101,9 +101,9 @@ func (r *DashboardRepository) GetOne(ctx context.Context, id string) (entity.Das
 		w := entity.DashboardWidget{Widget: new(entity.Widget)}
 
 		if err = rows.Scan(
-			&d.Id, &d.Name,
-			&w.Id, &w.Name, &w.Type, &parentId, &w.Props, &w.Query,
-			&w.X, &w.Y, &w.W, &w.H,
+			&d.Id, &d.Name, jsonb{&d.Filters},
+			&w.Id, &w.Name, &w.Type, &parentId, &w.Props, widgetQuery{w.Widget},
+			&w.X, &w.Y, &w.W, &w.H, jsonb{&w.Bindings}, jsonb{&w.Emits}, jsonb{&w.Consumes},
 		); err != nil {
 			return entity.Dashboard{}, err
 		}
@@ -132,18 +132,18 @@ func (r *DashboardRepository) GetOne(ctx context.Context, id string) (entity.Das
 func (r *DashboardRepository) getSelect() sq.SelectBuilder {
 	return r.db.Builder.
 		Select(
-			"d.id d_id", "d.name d_name",
+			"d.id d_id", "d.name d_name", "d.filters d_filters",
 			"w.id w_id", "w.name w_name", "w.type", "w.parent_id", "w.props", "w.query",
-			"dw.x", "dw.y", "dw.w", "dw.h",
+			"dw.x", "dw.y", "dw.w", "dw.h", "dw.bindings", "dw.emits", "dw.consumes",
 		).From("dashboard d").
 		LeftJoin("dashboard_widget dw ON dw.dashboard_id = d.id").
 		LeftJoin("widget w ON w.id = dw.widget_id").
 		Suffix("UNION").
 		SuffixExpr(r.db.Builder.
 			Select(
-				"r.d_id", "r.d_name",
+				"r.d_id", "r.d_name", "r.d_filters",
 				"w.id", "w.name", "w.type", "w.parent_id", "w.props", "w.query",
-				"0::SMALLINT", "0::SMALLINT", "0::SMALLINT", "0::SMALLINT",
+				"0::SMALLINT", "0::SMALLINT", "0::SMALLINT", "0::SMALLINT", "NULL::JSONB", "NULL::JSONB", "NULL::JSONB",
 			).
 			From("widget w").
 			Join("r ON r.w_id = w.parent_id"),
@@ -152,9 +152,9 @@ func (r *DashboardRepository) getSelect() sq.SelectBuilder {
 		Suffix(")").
 		SuffixExpr(r.db.Builder.
 			Select(
-				"r.d_id", "r.d_name",
+				"r.d_id", "r.d_name", "r.d_filters",
 				fmt.Sprintf("COALESCE(r.w_id, '%s')", nilUuid), "COALESCE(r.w_name, '')", "COALESCE(r.type, '')", "r.parent_id", "r.props", "r.query",
-				"COALESCE(r.x, 0)", "COALESCE(r.y, 0)", "COALESCE(r.w, 0)", "COALESCE(r.h, 0)",
+				"COALESCE(r.x, 0)", "COALESCE(r.y, 0)", "COALESCE(r.w, 0)", "COALESCE(r.h, 0)", "r.bindings", "r.emits", "r.consumes",
 			).
 			From("r"))
 }
@@ -174,8 +174,8 @@ func (r *DashboardRepository) Create(ctx context.Context, d entity.Dashboard) (s
 
 	err := r.db.Builder.
 		Insert("dashboard").
-		Columns("name").
-		Values(d.Name).
+		Columns("name", "filters").
+		Values(d.Name, jsonb{d.Filters}).
 		Suffix("RETURNING id").
 		QueryRowContext(ctx).
 		Scan(&d.Id)
@@ -198,11 +198,11 @@ func (r *DashboardRepository) AddWidgets(
 	if len(widgets) != 0 {
 		b := r.db.Builder.
 			Insert("dashboard_widget").
-			Columns("dashboard_id", "widget_id", "x", "y", "w", "h")
+			Columns("dashboard_id", "widget_id", "x", "y", "w", "h", "bindings", "emits", "consumes")
 
 		for _, w := range widgets {
 			b = b.
-				Values(dashboardId, w.Id, w.X, w.Y, w.W, w.H)
+				Values(dashboardId, w.Id, w.X, w.Y, w.W, w.H, jsonb{w.Bindings}, jsonb{w.Emits}, jsonb{w.Consumes})
 		}
 
 		if _, err := b.ExecContext(ctx); err != nil {
@@ -217,6 +217,7 @@ func (r *DashboardRepository) Edit(ctx context.Context, d entity.Dashboard) erro
 	_, err := r.db.Builder.
 		Update("dashboard").
 		Set("name", d.Name).
+		Set("filters", jsonb{d.Filters}).
 		Where("id = ?", d.Id).
 		ExecContext(ctx)
 	if err != nil {
@@ -240,4 +241,4 @@ func (r *DashboardRepository) DeleteWidgets(ctx context.Context, dashboardId str
 		Where("dashboard_id = ?", dashboardId).
 		ExecContext(ctx)
 	return err
-}
\ No newline at end of file
+}
@@ -0,0 +1,49 @@
+package repository
+
+import (
+	"database/sql/driver"
+	"datapointbackend/internal/entity"
+	"encoding/json"
+	"fmt"
+)
+
+// jsonb читает и записывает значение v как столбец JSONB, NULL соответствует nil.
+type jsonb struct {
+	v any
+}
+
+func (j jsonb) Scan(src any) error {
+	switch src := src.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return json.Unmarshal(src, j.v)
+	case string:
+		return json.Unmarshal([]byte(src), j.v)
+	default:
+		return fmt.Errorf("неожиданный тип JSONB %T", src)
+	}
+}
+
+func (j jsonb) Value() (driver.Value, error) {
+	b, err := json.Marshal(j.v)
+	if err != nil || string(b) == "null" {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// widgetQuery читает запрос виджета. Запрос, который не удалось разобрать, не мешает читать остальные виджеты:
+// виджет остается без запроса, а ошибка сохраняется в QueryError.
+type widgetQuery struct {
+	w *entity.Widget
+}
+
+func (q widgetQuery) Scan(src any) error {
+	if err := (jsonb{&q.w.Query}).Scan(src); err != nil {
+		q.w.Query, q.w.QueryError = nil, err.Error()
+	}
+
+	return nil
+}
@@ -0,0 +1,39 @@
+package repository
+
+import (
+	"datapointbackend/internal/entity"
+	"testing"
+)
+
+func TestWidgetQueryScan(t *testing.T) {
+	tests := []struct {
+		name      string
+		src       any
+		wantQuery bool
+		wantErr   bool
+	}{
+		{name: "null", src: nil},
+		{name: "query", src: []byte(`{"sourceId": "s", "type": "select"}`), wantQuery: true},
+		{name: "malformed", src: []byte(`{"sourceId": 1}`), wantErr: true},
+		{name: "not json", src: "{", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var w entity.Widget
+
+			//ошибка разбора не прерывает чтение остальных виджетов.
+			if err := (widgetQuery{&w}).Scan(tt.src); err != nil {
+				t.Fatalf("Scan err = %v", err)
+			}
+
+			if (w.Query != nil) != tt.wantQuery {
+				t.Errorf("query = %+v, want present = %v", w.Query, tt.wantQuery)
+			}
+
+			if (len(w.QueryError) != 0) != tt.wantErr {
+				t.Errorf("queryError = %q, want set = %v", w.QueryError, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"context"
+	"datapointbackend/internal/entity"
+	"datapointbackend/pkg/database"
+	"encoding/json"
+	sq "github.com/Masterminds/squirrel"
+	"time"
+)
+
+type SchemaRepository struct {
+	db *database.Database
+}
+
+func NewSchemaRepository(db *database.Database) *SchemaRepository {
+	return &SchemaRepository{db: db}
+}
+
+// GetLast возвращает не более limit последних снимков источника, созданных не позже before.
+func (r *SchemaRepository) GetLast(
+	ctx context.Context,
+	sourceId string,
+	before time.Time,
+	limit uint64,
+) ([]entity.SchemaSnapshot, error) {
+	rows, err := r.db.Builder.
+		Select("id", "source_id", "tables", "created_at").
+		From("schema_snapshot").
+		Where(sq.Eq{"source_id": sourceId}).
+		Where(sq.LtOrEq{"created_at": before}).
+		OrderBy("created_at DESC").
+		Limit(limit).
+		QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var sl []entity.SchemaSnapshot
+	for rows.Next() {
+		var (
+			s      entity.SchemaSnapshot
+			tables []byte
+		)
+		if err = rows.Scan(&s.Id, &s.SourceId, &tables, &s.CreatedAt); err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal(tables, &s.Tables); err != nil {
+			return nil, err
+		}
+		sl = append(sl, s)
+	}
+
+	return sl, rows.Err()
+}
+
+func (r *SchemaRepository) Create(ctx context.Context, s entity.SchemaSnapshot) (entity.SchemaSnapshot, error) {
+	tables, err := json.Marshal(s.Tables)
+	if err != nil {
+		return s, err
+	}
+
+	return s, r.db.Builder.
+		Insert("schema_snapshot").
+		Columns("source_id", "tables").
+		Values(s.SourceId, tables).
+		Suffix("RETURNING id, created_at").
+		QueryRowContext(ctx).
+		Scan(&s.Id, &s.CreatedAt)
+}
@@ -4,21 +4,46 @@ import (
 	"context"
 	"datapointbackend/internal/entity"
 	"datapointbackend/pkg/database"
+	"datapointbackend/pkg/envelope"
+	"fmt"
+	sq "github.com/Masterminds/squirrel"
 )
 
+// SourceRepository хранит секреты источников зашифрованными keys.
 type SourceRepository struct {
-	db *database.Database
+	db   *database.Database
+	keys *envelope.Keyring
 }
 
-func NewSourceRepository(db *database.Database) *SourceRepository {
-	return &SourceRepository{db: db}
+func NewSourceRepository(db *database.Database, keys *envelope.Keyring) *SourceRepository {
+	return &SourceRepository{db: db, keys: keys}
+}
+
+var sourceColumns = []string{
+	"id", "name", "host", "port", "username", "password", "password_ref", "database_name", "driver",
+	"default_timeout", "max_timeout", "max_rows", "max_bytes", "cache_ttl",
+	"max_open_conns", "max_idle_conns", "conn_max_lifetime", "conn_max_idle_time",
+	"ssl_mode", "ssl_root_cert", "ssl_cert", "ssl_key", "ssl_server_name",
+	"tunnel",
+}
+
+// sourceDest - места для сканирования столбцов sourceColumns.
+func sourceDest(s *entity.Source) []any {
+	return []any{
+		&s.Id, &s.Name, &s.Host, &s.Port, &s.Username, &s.Password, &s.PasswordRef, &s.DatabaseName, &s.Driver,
+		&s.DefaultTimeout, &s.MaxTimeout, &s.MaxRows, &s.MaxBytes, &s.CacheTTL,
+		&s.MaxOpenConns, &s.MaxIdleConns, &s.ConnMaxLifetime, &s.ConnMaxIdleTime,
+		&s.SSLMode, &s.SSLRootCert, &s.SSLCert, &s.SSLKey, &s.SSLServerName,
+		jsonb{&s.Tunnel},
+	}
 }
 
 func (r *SourceRepository) GetAll(ctx context.Context) ([]entity.Source, error) {
-	rows, err := r.db.Builder.
-		Select("id", "name", "host", "port", "username", "password", "database_name", "driver").
-		From("source").
-		QueryContext(ctx)
+	return r.getAll(ctx, r.db.Builder.Select(sourceColumns...).From("source"))
+}
+
+func (r *SourceRepository) getAll(ctx context.Context, b sq.SelectBuilder) ([]entity.Source, error) {
+	rows, err := b.QueryContext(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -27,35 +52,67 @@ func (r *SourceRepository) GetAll(ctx context.Context) ([]entity.Source, error)
 	var sl []entity.Source
 	for rows.Next() {
 		var s entity.Source
-		if err = rows.Scan(&s.Id, &s.Name, &s.Host, &s.Port, &s.Username, &s.Password, &s.DatabaseName, &s.Driver); err != nil {
+		if err = rows.Scan(sourceDest(&s)...); err != nil {
+			return nil, err
+		}
+		if err = r.decrypt(&s); err != nil {
 			return nil, err
 		}
 		sl = append(sl, s)
 	}
 
-	return sl, nil
+	return sl, rows.Err()
 }
 
 func (r *SourceRepository) GetOne(ctx context.Context, id string) (entity.Source, error) {
 	var s entity.Source
-	return s, r.db.Builder.
-		Select("id", "name", "host", "port", "username", "password", "database_name", "driver").
+
+	if err := r.db.Builder.
+		Select(sourceColumns...).
 		From("source").
 		Where("id = ?", id).
 		QueryRowContext(ctx).
-		Scan(&s.Id, &s.Name, &s.Host, &s.Port, &s.Username, &s.Password, &s.DatabaseName, &s.Driver)
+		Scan(sourceDest(&s)...); err != nil {
+		return s, err
+	}
+
+	return s, r.decrypt(&s)
 }
 
 func (r *SourceRepository) Edit(ctx context.Context, s entity.Source) error {
-	_, err := r.db.Builder.
+	return r.edit(ctx, r.db.Builder, s)
+}
+
+func (r *SourceRepository) edit(ctx context.Context, b sq.StatementBuilderType, s entity.Source) error {
+	if err := r.encrypt(&s); err != nil {
+		return err
+	}
+
+	_, err := b.
 		Update("source").
 		Set("name", s.Name).
 		Set("host", s.Host).
 		Set("port", s.Port).
 		Set("username", s.Username).
 		Set("password", s.Password).
+		Set("password_ref", s.PasswordRef).
 		Set("database_name", s.DatabaseName).
 		Set("driver", s.Driver).
+		Set("default_timeout", s.DefaultTimeout).
+		Set("max_timeout", s.MaxTimeout).
+		Set("max_rows", s.MaxRows).
+		Set("max_bytes", s.MaxBytes).
+		Set("cache_ttl", s.CacheTTL).
+		Set("max_open_conns", s.MaxOpenConns).
+		Set("max_idle_conns", s.MaxIdleConns).
+		Set("conn_max_lifetime", s.ConnMaxLifetime).
+		Set("conn_max_idle_time", s.ConnMaxIdleTime).
+		Set("ssl_mode", s.SSLMode).
+		Set("ssl_root_cert", s.SSLRootCert).
+		Set("ssl_cert", s.SSLCert).
+		Set("ssl_key", s.SSLKey).
+		Set("ssl_server_name", s.SSLServerName).
+		Set("tunnel", jsonb{s.Tunnel}).
 		Where("id = ?", s.Id).
 		ExecContext(ctx)
 	return err
@@ -70,12 +127,83 @@ func (r *SourceRepository) Delete(ctx context.Context, id string) error {
 }
 
 func (r *SourceRepository) Create(ctx context.Context, s entity.Source) (string, error) {
+	if err := r.encrypt(&s); err != nil {
+		return "", err
+	}
+
 	var id string
 	return id, r.db.Builder.
 		Insert("source").
-		Columns("name", "host", "port", "username", "password", "database_name", "driver").
-		Values(s.Name, s.Host, s.Port, s.Username, s.Password, s.DatabaseName, s.Driver).
+		Columns(sourceColumns[1:]...).
+		Values(
+			s.Name, s.Host, s.Port, s.Username, s.Password, s.PasswordRef, s.DatabaseName, s.Driver,
+			s.DefaultTimeout, s.MaxTimeout, s.MaxRows, s.MaxBytes, s.CacheTTL,
+			s.MaxOpenConns, s.MaxIdleConns, s.ConnMaxLifetime, s.ConnMaxIdleTime,
+			s.SSLMode, s.SSLRootCert, s.SSLCert, s.SSLKey, s.SSLServerName,
+			jsonb{s.Tunnel},
+		).
 		Suffix("RETURNING id").
 		QueryRowContext(ctx).
 		Scan(&id)
 }
+
+// Rotate перешифровывает секреты всех источников текущим мастер-ключом
+// и возвращает количество перешифрованных источников.
+// Источники перешифровываются в одной транзакции: при ошибке ни один не изменится.
+func (r *SourceRepository) Rotate(ctx context.Context) (int, error) {
+	tx, err := r.db.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	b := r.db.Builder.RunWith(tx)
+
+	//источники блокируются, чтобы одновременное изменение не сохранило секреты прежним ключом.
+	sl, err := r.getAll(ctx, b.Select(sourceColumns...).From("source").Suffix("FOR UPDATE"))
+	if err != nil {
+		return 0, err
+	}
+
+	for _, s := range sl {
+		if err = r.edit(ctx, b, s); err != nil {
+			return 0, fmt.Errorf("не удалось перешифровать источник %s: %s", s.Id, err.Error())
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return len(sl), nil
+}
+
+func (r *SourceRepository) encrypt(s *entity.Source) error {
+	//туннель общий с вызывающим, а шифруется копия.
+	if s.Tunnel != nil {
+		t := *s.Tunnel
+		s.Tunnel = &t
+	}
+
+	var err error
+
+	for _, secret := range s.Secrets() {
+		if *secret, err = r.keys.Encrypt(*secret); err != nil {
+			return fmt.Errorf("не удалось зашифровать секрет источника: %s", err.Error())
+		}
+	}
+
+	return nil
+}
+
+func (r *SourceRepository) decrypt(s *entity.Source) error {
+	var err error
+
+	for _, secret := range s.Secrets() {
+		if *secret, err = r.keys.Decrypt(*secret); err != nil {
+			return fmt.Errorf("не удалось расшифровать секрет источника %s: %s", s.Id, err.Error())
+		}
+	}
+
+	return nil
+}
@@ -1,10 +1,12 @@
 package repository
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
 	"datapointbackend/internal/entity"
 	"datapointbackend/pkg/database"
+	"encoding/json"
 	"fmt"
 	sq "github.com/Masterminds/squirrel"
 )
@@ -43,7 +45,7 @@ func (r *WidgetRepository) GetAll(ctx context.Context) ([]entity.Widget, error)
 	for rows.Next() {
 		e := extended{Widget: new(entity.Widget)}
 
-		if err = rows.Scan(&e.Id, &e.Name, &e.Type, &e.Props, &e.Query, &e.parentId); err != nil {
+		if err = rows.Scan(&e.Id, &e.Name, &e.Type, &e.Props, widgetQuery{e.Widget}, &e.parentId); err != nil {
 			return nil, err
 		}
 
@@ -114,7 +116,7 @@ func (r *WidgetRepository) GetOne(ctx context.Context, id string) (entity.Widget
 	for rows.Next() {
 		e := extended{Widget: new(entity.Widget)}
 
-		if err = rows.Scan(&e.Id, &e.Name, &e.Type, &e.Props, &e.Query, &e.parentId); err != nil {
+		if err = rows.Scan(&e.Id, &e.Name, &e.Type, &e.Props, widgetQuery{e.Widget}, &e.parentId); err != nil {
 			return entity.Widget{}, err
 		}
 
@@ -150,7 +152,7 @@ func (r *WidgetRepository) Create(ctx context.Context, w entity.Widget, parentId
 	err := r.db.Builder.
 		Insert("widget").
 		Columns("name", "type", "props", "query", "parent_id").
-		Values(w.Name, w.Type, w.Props, w.Query, parentId).
+		Values(w.Name, w.Type, w.Props, jsonb{w.Query}, parentId).
 		Suffix("RETURNING id").
 		QueryRowContext(ctx).
 		Scan(&w.Id)
@@ -177,7 +179,7 @@ func (r *WidgetRepository) Edit(ctx context.Context, w entity.Widget) error {
 		Set("name", w.Name).
 		Set("type", w.Type).
 		Set("props", w.Props).
-		Set("query", w.Query).
+		Set("query", jsonb{w.Query}).
 		Where("id = ?", w.Id).
 		ExecContext(ctx)
 	if err != nil {
@@ -222,3 +224,94 @@ func (r *WidgetRepository) ClearChildren(ctx context.Context, ignore []string, p
 		ExecContext(ctx)
 	return err
 }
+
+// queriesMigration - имя миграции MigrateQueries в таблице migration.
+const queriesMigration = "widget_queries"
+
+// QueriesMigrated проверяет, что MigrateQueries уже выполнена.
+func (r *WidgetRepository) QueriesMigrated(ctx context.Context) (bool, error) {
+	var count int
+
+	if err := r.db.Builder.
+		Select("count(*)").
+		From("migration").
+		Where("name = ?", queriesMigration).
+		QueryRowContext(ctx).
+		Scan(&count); err != nil {
+		return false, err
+	}
+
+	return count != 0, nil
+}
+
+// MigrateQueries переписывает сохраненные запросы виджетов в типизированном виде
+// и возвращает количество переписанных запросов и ошибки разбора остальных по идентификаторам виджетов.
+// Запросы, которые не удалось разобрать, остаются как есть и возвращаются у виджетов с QueryError.
+func (r *WidgetRepository) MigrateQueries(ctx context.Context) (int, map[string]error, error) {
+	rows, err := r.db.Builder.
+		Select("id", "query").
+		From("widget").
+		Where("query IS NOT NULL").
+		QueryContext(ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	queries := make(map[string][]byte)
+
+	for rows.Next() {
+		var (
+			id    string
+			query []byte
+		)
+		if err = rows.Scan(&id, &query); err != nil {
+			_ = rows.Close()
+			return 0, nil, err
+		}
+		queries[id] = query
+	}
+
+	_ = rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return 0, nil, err
+	}
+
+	var (
+		migrated int
+		failed   = make(map[string]error)
+	)
+
+	for id, raw := range queries {
+		var query *entity.Query
+
+		decoder := json.NewDecoder(bytes.NewReader(raw))
+		decoder.DisallowUnknownFields()
+
+		if err = decoder.Decode(&query); err != nil {
+			failed[id] = err
+			continue
+		}
+
+		if _, err = r.db.Builder.
+			Update("widget").
+			Set("query", jsonb{query}).
+			Where("id = ?", id).
+			ExecContext(ctx); err != nil {
+			return migrated, failed, err
+		}
+
+		migrated++
+	}
+
+	if _, err = r.db.Builder.
+		Insert("migration").
+		Columns("name").
+		Values(queriesMigration).
+		Suffix("ON CONFLICT (name) DO NOTHING").
+		ExecContext(ctx); err != nil {
+		return migrated, failed, err
+	}
+
+	return migrated, failed, nil
+}
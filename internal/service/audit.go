@@ -0,0 +1,108 @@
+package service
+
+import (
+	"context"
+	"datapointbackend/internal/entity"
+	"datapointbackend/pkg/database"
+	"encoding/json"
+	"fmt"
+)
+
+type auditRepository interface {
+	GetAll(ctx context.Context, f entity.AuditFilter) ([]entity.Audit, error)
+	Create(ctx context.Context, a entity.Audit) (string, error)
+	SetStatus(ctx context.Context, id string, status string) error
+}
+
+type AuditService struct {
+	ar auditRepository
+}
+
+func NewAuditService(ar auditRepository) *AuditService {
+	return &AuditService{ar: ar}
+}
+
+const defaultAuditLimit = 100
+
+func (s *AuditService) GetAll(ctx context.Context, f entity.AuditFilter) ([]entity.Audit, error) {
+	if f.Limit == 0 {
+		f.Limit = defaultAuditLimit
+	}
+
+	sl, err := s.ar.GetAll(ctx, f)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось получить журнал аудита: %s", err.Error())
+	}
+	return sl, nil
+}
+
+// Prepare сохраняет изменение данных источника в журнал аудита до фиксации изменения
+// и возвращает идентификатор записи со статусом pending.
+func (s *AuditService) Prepare(ctx context.Context, query entity.Query, m database.Mutation) (string, error) {
+	a := entity.Audit{
+		SourceId:     query.SourceId,
+		Type:         m.Type,
+		Table:        m.Table,
+		RawSql:       m.RawSql,
+		RowsAffected: m.RowsAffected,
+		Caller:       CallerFrom(ctx),
+		Status:       entity.AuditPending,
+	}
+
+	var err error
+
+	if a.Query, err = rawJSON(query); err != nil {
+		return "", err
+	}
+
+	if a.Args, err = rawJSON(m.Args); err != nil {
+		return "", err
+	}
+
+	if m.Before != nil {
+		if a.Before, err = rawJSON(m.Before); err != nil {
+			return "", err
+		}
+	}
+
+	if m.After != nil {
+		if a.After, err = rawJSON(m.After); err != nil {
+			return "", err
+		}
+	}
+
+	return s.ar.Create(ctx, a)
+}
+
+// Complete отмечает в записи журнала аудита, зафиксировано ли изменение.
+func (s *AuditService) Complete(ctx context.Context, id string, committed bool) error {
+	status := entity.AuditRolledBack
+	if committed {
+		status = entity.AuditCommitted
+	}
+
+	return s.ar.SetStatus(ctx, id, status)
+}
+
+func rawJSON(v any) (*json.RawMessage, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := json.RawMessage(data)
+
+	return &raw, nil
+}
+
+type callerKey struct{}
+
+// WithCaller добавляет в контекст того, кто исполняет запрос.
+func WithCaller(ctx context.Context, caller entity.Caller) context.Context {
+	return context.WithValue(ctx, callerKey{}, caller)
+}
+
+func CallerFrom(ctx context.Context) entity.Caller {
+	caller, _ := ctx.Value(callerKey{}).(entity.Caller)
+	return caller
+}
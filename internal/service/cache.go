@@ -0,0 +1,124 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"datapointbackend/pkg/cache"
+	"datapointbackend/pkg/database"
+	"encoding/hex"
+	"encoding/json"
+	"golang.org/x/sync/singleflight"
+	"sync"
+)
+
+// resultCache хранит результаты select до истечения времени хранения или изменения таблиц,
+// из которых они получены.
+//
+// Каждое изменение таблицы увеличивает ее счетчик поколения, а поколения таблиц входят в ключ результата,
+// поэтому после изменения результат больше не находится и удаляется хранилищем по истечении срока.
+type resultCache struct {
+	c *cache.Cache
+
+	group singleflight.Group //объединяет одновременные одинаковые запросы этого экземпляра.
+
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+// flight - общее исполнение одновременных одинаковых запросов.
+type flight struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	waiters int
+}
+
+func newResultCache(storage cache.Storage) *resultCache {
+	return &resultCache{
+		c:       cache.NewCache(storage, "result"),
+		flights: make(map[string]*flight),
+	}
+}
+
+// join возвращает контекст общего исполнения по ключу group и функцию, которую ожидающий вызывает,
+// когда получил результат или перестал его ждать. Контекст не зависит от того, кто из ожидающих
+// отключится первым, и отменяется, только когда результат больше никто не ждет.
+func (rc *resultCache) join(ctx context.Context, key string) (context.Context, func()) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	f, ok := rc.flights[key]
+	if !ok {
+		f = &flight{}
+		f.ctx, f.cancel = context.WithCancel(context.WithoutCancel(ctx))
+		rc.flights[key] = f
+	}
+
+	f.waiters++
+
+	return f.ctx, func() {
+		rc.mu.Lock()
+		defer rc.mu.Unlock()
+
+		if f.waiters--; f.waiters != 0 {
+			return
+		}
+
+		f.cancel()
+		delete(rc.flights, key)
+
+		//отмененное исполнение не должно достаться следующему запросу.
+		rc.group.Forget(key)
+	}
+}
+
+// key - ключ результата select: источник, скомпилированный запрос, поколения таблиц
+// и все, что влияет на результат помимо них.
+func (rc *resultCache) key(
+	ctx context.Context,
+	sourceId string,
+	cfg database.Config,
+	query database.Query,
+	rawSql string,
+	args []any,
+) (string, error) {
+	tables := query.Table.Names()
+	generations := make([]int64, len(tables))
+
+	for i, table := range tables {
+		var err error
+		if generations[i], err = rc.c.Counter(ctx, generationKey(sourceId, table)); err != nil {
+			return "", err
+		}
+	}
+
+	data, err := json.Marshal([]any{
+		rawSql, args, generations, query.Total, query.TimeZone, cfg.Limits,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+
+	return "data:" + sourceId + ":" + hex.EncodeToString(sum[:]), nil
+}
+
+func generationKey(sourceId, table string) string {
+	return "generation:" + sourceId + ":" + table
+}
+
+// invalidate делает устаревшими все результаты, полученные из таблицы источника.
+func (rc *resultCache) invalidate(ctx context.Context, sourceId, table string) error {
+	_, err := rc.c.Incr(ctx, generationKey(sourceId, table))
+	return err
+}
+
+// purge удаляет результаты источника, а если он не указан, то все результаты.
+func (rc *resultCache) purge(ctx context.Context, sourceId string) (int, error) {
+	prefix := "data:"
+	if len(sourceId) != 0 {
+		prefix += sourceId + ":"
+	}
+
+	return rc.c.Purge(ctx, prefix)
+}
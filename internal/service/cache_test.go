@@ -0,0 +1,42 @@
+package service
+
+import (
+	"context"
+	"datapointbackend/pkg/cache"
+	"testing"
+)
+
+func TestResultCacheJoin(t *testing.T) {
+	rc := newResultCache(cache.NewMemory(0, 0))
+
+	first, cancel := context.WithCancel(context.Background())
+
+	shared, leaveFirst := rc.join(first, "key")
+	again, leaveSecond := rc.join(context.Background(), "key")
+
+	if shared != again {
+		t.Fatal("waiters of one key got different contexts")
+	}
+
+	//первый ожидающий отключился, но результат еще ждет второй.
+	cancel()
+	leaveFirst()
+
+	if shared.Err() != nil {
+		t.Fatalf("shared context canceled with a waiter left: %v", shared.Err())
+	}
+
+	leaveSecond()
+
+	if shared.Err() == nil {
+		t.Fatal("shared context is not canceled after all waiters left")
+	}
+
+	//следующий запрос получает новое исполнение.
+	next, leave := rc.join(context.Background(), "key")
+	defer leave()
+
+	if next.Err() != nil {
+		t.Fatalf("next context is canceled: %v", next.Err())
+	}
+}
@@ -16,10 +16,11 @@ type dashboardRepository interface {
 
 type DashboardService struct {
 	dr dashboardRepository
+	ws *WidgetService
 }
 
-func NewDashboardService(dr dashboardRepository) *DashboardService {
-	return &DashboardService{dr: dr}
+func NewDashboardService(dr dashboardRepository, ws *WidgetService) *DashboardService {
+	return &DashboardService{dr: dr, ws: ws}
 }
 
 func (s *DashboardService) GetAll(ctx context.Context) ([]entity.Dashboard, error) {
@@ -46,6 +47,10 @@ func (s *DashboardService) Delete(ctx context.Context, id string) error {
 }
 
 func (s *DashboardService) Create(ctx context.Context, d entity.Dashboard) (string, error) {
+	if err := validateDashboard(d); err != nil {
+		return "", err
+	}
+
 	id, err := s.dr.Create(ctx, d)
 	if err != nil {
 		return "", fmt.Errorf("не удалось сохранить дашборд: %s", err.Error())
@@ -54,6 +59,10 @@ func (s *DashboardService) Create(ctx context.Context, d entity.Dashboard) (stri
 }
 
 func (s *DashboardService) Edit(ctx context.Context, d entity.Dashboard) error {
+	if err := validateDashboard(d); err != nil {
+		return err
+	}
+
 	if err := s.dr.Edit(ctx, d); err != nil {
 		return err
 	}
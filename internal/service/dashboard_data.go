@@ -0,0 +1,234 @@
+package service
+
+import (
+	"context"
+	"datapointbackend/internal/entity"
+	"datapointbackend/pkg/database"
+	"fmt"
+	"strings"
+)
+
+// GetData исполняет запросы всех виджетов дашборда с выбранными фильтрами
+// и возвращает результаты по идентификаторам виджетов.
+func (s *DashboardService) GetData(
+	ctx context.Context,
+	id string,
+	input entity.DashboardInput,
+) (map[string]database.QResponse, error) {
+	d, err := s.dr.GetOne(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	inputs, err := s.Inputs(d, input)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make(map[string]database.QResponse)
+
+	s.Render(ctx, d, inputs, func(wd entity.WidgetData) {
+		data[wd.WidgetId] = wd.QResponse
+	})
+
+	return data, nil
+}
+
+// Render параллельно исполняет запросы всех виджетов дашборда с изменениями inputs
+// и передает каждый результат в emit, как только он готов.
+func (s *DashboardService) Render(
+	ctx context.Context,
+	d entity.Dashboard,
+	inputs map[string]entity.WidgetInput,
+	emit func(wd entity.WidgetData),
+) {
+	widgets := make([]entity.Widget, 0, len(d.Widgets))
+	for _, dw := range d.Widgets {
+		widgets = append(widgets, *dw.Widget)
+	}
+
+	s.ws.run(ctx, widgets, func(id string) entity.WidgetInput { return inputs[id] }, emit)
+}
+
+// Inputs возвращает изменения запросов виджетов дашборда по их идентификаторам:
+// выбранные значения фильтров и значения, выбранные на других виджетах, становятся условиями
+// на привязанные к ним столбцы. Виджеты без привязки фильтр и выбор не затрагивают.
+// Привязки относятся к запросу самого виджета дашборда, поэтому его дочерние виджеты не фильтруются:
+// их запросы могут обращаться к другим источникам с таблицами тех же имен.
+func (s *DashboardService) Inputs(d entity.Dashboard, input entity.DashboardInput) (map[string]entity.WidgetInput, error) {
+	filters := make(map[string]entity.DashboardFilter, len(d.Filters))
+	for _, f := range d.Filters {
+		filters[f.Id] = f
+	}
+
+	for id := range input.Filters {
+		if _, ok := filters[id]; !ok {
+			return nil, fmt.Errorf("у дашборда нет фильтра %s", id)
+		}
+	}
+
+	selections, err := selectionIndex(d, input.Selections)
+	if err != nil {
+		return nil, err
+	}
+
+	inputs := make(map[string]entity.WidgetInput)
+
+	for _, dw := range d.Widgets {
+		var wi entity.WidgetInput
+
+		for _, b := range dw.Bindings {
+			f, ok := filters[b.FilterId]
+			if !ok {
+				continue
+			}
+
+			value, ok := input.Filters[f.Id]
+			if !ok || value == nil {
+				value = f.Default
+			}
+
+			if value == nil {
+				continue
+			}
+
+			column, err := filterColumn(f, b, value)
+			if err != nil {
+				return nil, err
+			}
+
+			wi.Filters = append(wi.Filters, column)
+		}
+
+		for _, c := range dw.Consumes {
+			//виджет не фильтруется собственным выбором.
+			values := selections[selectionKey{c.WidgetId, c.MetaKey}]
+			if c.WidgetId == dw.Id || len(values) == 0 {
+				continue
+			}
+
+			wi.Filters = append(wi.Filters, &database.QColumn{
+				Column:   database.Column{Name: c.Column},
+				TableKey: c.TableKey,
+				Value:    values,
+			})
+		}
+
+		if len(wi.Filters) != 0 {
+			inputs[dw.Id] = wi
+		}
+	}
+
+	return inputs, nil
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// filterColumn возвращает условие на привязанный столбец для значения фильтра.
+func filterColumn(f entity.DashboardFilter, b entity.FilterBinding, value any) (*database.QColumn, error) {
+	column := database.QColumn{
+		Column:   database.Column{Name: b.Column},
+		TableKey: b.TableKey,
+		Value:    value,
+	}
+
+	switch f.Type {
+	case entity.FilterDateRange:
+		bounds, ok := value.([]any)
+		if !ok || len(bounds) != 2 {
+			return nil, fmt.Errorf("значение фильтра %s должно быть массивом [от, до]", f.Name)
+		}
+		column.Payload = map[string]any{database.Operator: database.OpBetween}
+
+	case entity.FilterDimension:
+
+	case entity.FilterSearch:
+		text, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("значение фильтра %s должно быть строкой", f.Name)
+		}
+		if len(text) == 0 {
+			column.Value = nil
+		} else {
+			column.Value = "%" + likeEscaper.Replace(text) + "%"
+		}
+		column.Payload = map[string]any{database.Operator: database.OpILike}
+
+	default:
+		return nil, fmt.Errorf("неизвестный тип %s фильтра %s", f.Type, f.Name)
+	}
+
+	return &column, nil
+}
+
+type selectionKey struct {
+	widgetId string
+	metaKey  string
+}
+
+// emitted возвращает пары виджет и metaKey, значения которых можно выбрать на дашборде.
+func emitted(d entity.Dashboard) map[selectionKey]bool {
+	emits := make(map[selectionKey]bool)
+	for _, dw := range d.Widgets {
+		for _, metaKey := range dw.Emits {
+			emits[selectionKey{dw.Id, metaKey}] = true
+		}
+	}
+
+	return emits
+}
+
+// selectionIndex возвращает выбранные значения по виджетам и metaKey,
+// выбирать можно только значения, которые виджет объявил в Emits.
+func selectionIndex(d entity.Dashboard, selections []entity.Selection) (map[selectionKey][]any, error) {
+	emits := emitted(d)
+	index := make(map[selectionKey][]any, len(selections))
+
+	for _, s := range selections {
+		key := selectionKey{s.WidgetId, s.MetaKey}
+		if !emits[key] {
+			return nil, fmt.Errorf("на виджете %s нельзя выбрать значения %s", s.WidgetId, s.MetaKey)
+		}
+
+		index[key] = append(index[key], s.Values...)
+	}
+
+	return index, nil
+}
+
+// validateDashboard проверяет фильтры дашборда, выбор на виджетах и привязки к ним.
+func validateDashboard(d entity.Dashboard) error {
+	filters := make(map[string]bool, len(d.Filters))
+
+	for _, f := range d.Filters {
+		switch f.Type {
+		case entity.FilterDateRange, entity.FilterDimension, entity.FilterSearch:
+		default:
+			return fmt.Errorf("неизвестный тип %s фильтра %s", f.Type, f.Name)
+		}
+
+		if len(f.Id) == 0 || filters[f.Id] {
+			return fmt.Errorf("у фильтра %s должен быть уникальный идентификатор", f.Name)
+		}
+
+		filters[f.Id] = true
+	}
+
+	emits := emitted(d)
+
+	for _, dw := range d.Widgets {
+		for _, b := range dw.Bindings {
+			if !filters[b.FilterId] {
+				return fmt.Errorf("виджет %s привязан к отсутствующему фильтру %s", dw.Id, b.FilterId)
+			}
+		}
+
+		for _, c := range dw.Consumes {
+			if !emits[selectionKey{c.WidgetId, c.MetaKey}] {
+				return fmt.Errorf("виджет %s привязан к выбору %s, который виджет %s не объявил", dw.Id, c.MetaKey, c.WidgetId)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,52 @@
+package service
+
+import (
+	"datapointbackend/internal/entity"
+	"datapointbackend/pkg/database"
+	"testing"
+)
+
+func TestInputsChildren(t *testing.T) {
+	orders := database.QTableKey{Name: "orders"}
+
+	query := func(sourceId string) *entity.Query {
+		return &entity.Query{SourceId: sourceId, Query: database.Query{Table: &database.QTable{QTableKey: orders}}}
+	}
+
+	//дочерний виджет читает таблицу с тем же именем из другого источника.
+	child := &entity.Widget{Id: "child", Query: query("warehouse")}
+
+	d := entity.Dashboard{
+		Filters: []entity.DashboardFilter{{Id: "region", Name: "Регион", Type: entity.FilterDimension}},
+		Widgets: []*entity.DashboardWidget{
+			{
+				Widget:   &entity.Widget{Id: "group", Query: query("shop"), Children: []*entity.Widget{child}},
+				Bindings: []entity.FilterBinding{{FilterId: "region", TableKey: orders, Column: "region"}},
+			},
+		},
+	}
+
+	inputs, err := (&DashboardService{}).Inputs(d, entity.DashboardInput{Filters: map[string]any{"region": "north"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wi, ok := inputs["group"]
+	if !ok || len(wi.Filters) != 1 || wi.Filters[0].Value != "north" {
+		t.Errorf("inputs[group] = %+v", wi)
+	}
+
+	//привязка виджета дашборда не распространяется на дочерний виджет.
+	if wi, ok := inputs["child"]; ok {
+		t.Fatalf("inputs[child] = %+v, want none", wi)
+	}
+
+	q, err := applyInput(child.Query.Query, inputs["child"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(q.Where) != 0 {
+		t.Errorf("child where = %+v, want none", q.Where)
+	}
+}
@@ -3,22 +3,304 @@ package service
 import (
 	"context"
 	"datapointbackend/internal/entity"
+	"datapointbackend/pkg/cache"
 	"datapointbackend/pkg/database"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type QueryService struct {
 	ss *SourceService
+	as *AuditService
+
+	maxExportRows uint64
+
+	mu      sync.Mutex
+	running map[string]*running
+
+	cache *resultCache
 }
 
-func NewQueryService(ss *SourceService) *QueryService {
-	return &QueryService{ss: ss}
+// running - исполняемый запрос и способ его отменить.
+type running struct {
+	entity.RunningQuery
+	cancel context.CancelFunc
+}
+
+// ErrNotRunning - запрос уже завершен или не исполнялся.
+var ErrNotRunning = errors.New("запрос не исполняется")
+
+func NewQueryService(ss *SourceService, as *AuditService, maxExportRows uint64) *QueryService {
+	return &QueryService{
+		ss:            ss,
+		as:            as,
+		maxExportRows: maxExportRows,
+		running:       make(map[string]*running),
+		cache:         ss.results, //источник очищает свои результаты при изменении и удалении.
+	}
 }
 
 func (s *QueryService) Execute(ctx context.Context, query entity.Query) database.QResponse {
-	db, err := s.ss.GetDatabase(query.SourceId)
+	db, release, err := s.ss.GetDatabase(query.SourceId)
 	if err != nil {
 		return database.QResponse{}.Errorf(err.Error())
 	}
+	defer release()
+
+	if query.Query, err = query.Bind(query.Values); err != nil {
+		return database.QResponse{}.Errorf("не удалось подставить параметры запроса: %s", err.Error())
+	}
+
+	return s.executeBound(ctx, db, query)
+}
+
+// executeBound исполняет запрос, параметры которого уже подставлены.
+func (s *QueryService) executeBound(ctx context.Context, db *database.Database, query entity.Query) database.QResponse {
+	if ttl := cacheTTL(db.Config, query.Query); ttl != 0 {
+		return s.executeCached(ctx, db, query, ttl)
+	}
+
+	return s.execute(ctx, db, query)
+}
+
+// cacheTTL возвращает время хранения результата запроса, 0 - результат не кэшируется.
+func cacheTTL(cfg database.Config, query database.Query) time.Duration {
+	if query.Type != database.Select {
+		return 0
+	}
+
+	ttl := cfg.CacheTTL
+	if query.CacheTTL != nil {
+		ttl = *query.CacheTTL
+	}
+
+	return time.Duration(ttl) * time.Second
+}
+
+// executeCached возвращает результат select из кэша или исполняет запрос один раз
+// для всех одновременных одинаковых запросов.
+func (s *QueryService) executeCached(
+	ctx context.Context,
+	db *database.Database,
+	query entity.Query,
+	ttl time.Duration,
+) database.QResponse {
+	rawSql, args, err := db.Compile(ctx, query.Query)
+	if err != nil {
+		//ошибку вернет сам запрос.
+		return s.execute(ctx, db, query)
+	}
+
+	var key string
+
+	//поколения таблиц читаются до исполнения, поэтому изменение во время исполнения
+	//не оставит в кэше устаревший результат под актуальным ключом.
+	if key, err = s.cache.key(ctx, query.SourceId, db.Config, query.Query, rawSql, args); err != nil {
+		return s.execute(ctx, db, query)
+	}
+
+	var response database.QResponse
+
+	if s.cache.c.Get(ctx, key, &response) {
+		response.Cached = true
+		return response
+	}
+
+	//запросы с разным временем ожидания исполняются отдельно, чтобы каждый получил свое ограничение.
+	group := fmt.Sprintf("%s:%d", key, db.Config.Timeout(query.Timeout))
+
+	shared, leave := s.cache.join(ctx, group)
+	defer leave()
+
+	var executed atomic.Bool
+
+	ch := s.cache.group.DoChan(group, func() (any, error) {
+		//исполнение может пережить ожидающего, который его начал, поэтому держит подключение само.
+		hold, release, err := s.ss.GetDatabase(query.SourceId)
+		if err != nil {
+			return database.QResponse{}.Errorf(err.Error()), nil
+		}
+		defer release()
+
+		//ключ результата получен по прежним настройкам источника.
+		if hold != db {
+			return database.QResponse{}.Errorf("источник %s изменен во время исполнения запроса", query.SourceId), nil
+		}
+
+		executed.Store(true)
+		result := s.execute(shared, db, query)
+
+		if len(result.Err) == 0 {
+			//результат уже получен, ошибка хранилища учитывается в статистике кэша.
+			_ = s.cache.c.Set(shared, key, result, ttl)
+		}
+
+		return result, nil
+	})
+
+	//каждый ожидающий может перестать ждать, не прерывая исполнение для остальных.
+	select {
+	case r := <-ch:
+		response = r.Val.(database.QResponse)
+	case <-ctx.Done():
+		return database.QResponse{}.Errorf("запрос отменен: %s", ctx.Err().Error())
+	}
+
+	//результат, полученный другим одновременным запросом, тоже считается взятым из кэша.
+	response.Cached = !executed.Load() && len(response.Err) == 0
+
+	return response
+}
+
+func (s *QueryService) execute(ctx context.Context, db *database.Database, query entity.Query) database.QResponse {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	id := s.track(ctx, query, cancel)
+	defer s.untrack(id)
+
+	response := db.Execute(database.WithTrace(ctx, &database.Trace{
+		//журнал и источник - разные базы, поэтому запись журнала сохраняется до фиксации изменения:
+		//без нее изменение не фиксируется, а сбой до фиксации оставляет запись в статусе pending.
+		Mutated: func(m database.Mutation) (func(bool), error) {
+			id, err := s.as.Prepare(ctx, query, m)
+			if err != nil {
+				return nil, fmt.Errorf("не удалось записать журнал аудита: %s", err.Error())
+			}
+
+			return func(committed bool) {
+				//изменение уже зафиксировано или отменено, ошибка оставляет запись в статусе pending.
+				_ = s.as.Complete(context.WithoutCancel(ctx), id, committed)
+			}, nil
+		},
+	}), query.Query)
+
+	if response.Mutation != nil {
+		//изменения через триггеры и каскадные ограничения не отслеживаются.
+		if err := s.cache.invalidate(ctx, query.SourceId, response.Mutation.Table); err != nil {
+			return response.Errorf("запрос исполнен, но не удалось сбросить кэш результатов: %s", err.Error())
+		}
+	}
+
+	return response
+}
+
+// Rows - строки потоковой выгрузки. Пока строки не закрыты, выгрузка числится
+// среди исполняемых запросов и ее можно отменить.
+type Rows struct {
+	*database.Rows
+	done func()
+}
+
+func (r *Rows) Close() error {
+	defer r.done()
+	return r.Rows.Close()
+}
+
+// Export исполняет select для потоковой выгрузки, вызывающий обязан закрыть строки.
+func (s *QueryService) Export(ctx context.Context, query entity.Query) (*Rows, error) {
+	db, release, err := s.ss.GetDatabase(query.SourceId)
+	if err != nil {
+		return nil, err
+	}
+
+	if query.Query, err = query.Bind(query.Values); err != nil {
+		release()
+		return nil, fmt.Errorf("не удалось подставить параметры запроса: %s", err.Error())
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	id := s.track(ctx, query, cancel)
+
+	done := func() {
+		s.untrack(id)
+		cancel()
+		release()
+	}
+
+	rows, err := db.Open(ctx, query.Query, s.maxExportRows)
+	if err != nil {
+		done()
+		return nil, err
+	}
+
+	return &Rows{Rows: rows, done: done}, nil
+}
+
+// PurgeCache удаляет кэшированные результаты источника, а если он не указан, то все,
+// и возвращает количество удаленных результатов.
+func (s *QueryService) PurgeCache(ctx context.Context, sourceId string) (int, error) {
+	count, err := s.cache.purge(ctx, sourceId)
+	if err != nil {
+		return count, fmt.Errorf("не удалось очистить кэш результатов: %s", err.Error())
+	}
+	return count, nil
+}
+
+// CacheStats возвращает счетчики попаданий в кэш результатов.
+func (s *QueryService) CacheStats() cache.Stats {
+	return s.cache.c.Stats()
+}
+
+// track добавляет запрос в список исполняемых и возвращает его идентификатор.
+func (s *QueryService) track(ctx context.Context, query entity.Query, cancel context.CancelFunc) string {
+	r := running{
+		RunningQuery: entity.RunningQuery{
+			Id:        uuid.NewString(),
+			SourceId:  query.SourceId,
+			Type:      query.Type,
+			Caller:    CallerFrom(ctx),
+			StartedAt: time.Now(),
+		},
+		cancel: cancel,
+	}
+
+	if query.Table != nil {
+		r.Table = query.Table.Name
+	}
+
+	s.mu.Lock()
+	s.running[r.Id] = &r
+	s.mu.Unlock()
+
+	return r.Id
+}
+
+func (s *QueryService) untrack(id string) {
+	s.mu.Lock()
+	delete(s.running, id)
+	s.mu.Unlock()
+}
+
+func (s *QueryService) GetRunning() []entity.RunningQuery {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sl := make([]entity.RunningQuery, 0, len(s.running))
+	for _, r := range s.running {
+		sl = append(sl, r.RunningQuery)
+	}
+
+	return sl
+}
+
+// Cancel отменяет контекст исполняемого запроса: ожидание прерывается, а драйвер отправляет источнику
+// запрос отмены для подключения, которое занимает этот запрос, поэтому чужие запросы не затрагиваются.
+func (s *QueryService) Cancel(id string) error {
+	s.mu.Lock()
+	r, ok := s.running[id]
+	s.mu.Unlock()
+
+	if !ok {
+		return ErrNotRunning
+	}
+
+	r.cancel()
 
-	return db.Execute(ctx, query.Query)
+	return nil
 }
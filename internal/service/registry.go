@@ -0,0 +1,274 @@
+package service
+
+import (
+	"context"
+	"datapointbackend/internal/entity"
+	"datapointbackend/pkg/database"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	minReconnectDelay = time.Second
+	maxReconnectDelay = 5 * time.Minute
+)
+
+// registry - подключения к источникам, безопасные для одновременного использования.
+// Источник подключается при первом обращении, а после неудачи - повторно с растущей задержкой.
+// Подключение измененного или удаленного источника закрывается, когда его освободят все,
+// кто его получил, чтобы не прерывать исполняемые запросы.
+type registry struct {
+	connect func(id string, source entity.Source) (*database.Database, error)
+
+	mu      sync.Mutex
+	sources map[string]*registered
+}
+
+type registered struct {
+	source  entity.Source
+	conn    *conn //только в состоянии connected.
+	state   string
+	lastErr error
+	retries int       //неудачных попыток подряд.
+	retryAt time.Time //раньше этого времени после неудачи подключение не повторяется.
+	done    chan struct{}
+}
+
+// conn - подключение и количество его пользователей.
+type conn struct {
+	db      *database.Database
+	users   int
+	retired bool //источник изменен или удален, подключение закроет последний пользователь.
+}
+
+func newRegistry(connect func(id string, source entity.Source) (*database.Database, error)) *registry {
+	return &registry{connect: connect, sources: make(map[string]*registered)}
+}
+
+// add добавляет источник, db == nil - источник подключится при первом обращении.
+func (r *registry) add(id string, source entity.Source, db *database.Database) {
+	e := registered{source: source, state: entity.SourceIdle}
+	if db != nil {
+		e.conn, e.state = &conn{db: db}, entity.SourceConnected
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if old := r.sources[id]; old != nil {
+		r.retire(old)
+	}
+
+	r.sources[id] = &e
+}
+
+func (r *registry) remove(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if e := r.sources[id]; e != nil {
+		r.retire(e)
+		delete(r.sources, id)
+	}
+}
+
+// retire закрывает подключение источника, как только у него не останется пользователей.
+// Вызывается под r.mu.
+func (r *registry) retire(e *registered) {
+	if e.conn == nil {
+		return
+	}
+
+	e.conn.retired = true
+
+	if e.conn.users == 0 {
+		go e.conn.db.Close()
+	}
+}
+
+// acquire отмечает, что подключение используется, и возвращает функцию его освобождения.
+// Вызывается под r.mu.
+func (r *registry) acquire(c *conn) func() {
+	c.users++
+
+	var once sync.Once
+
+	return func() {
+		once.Do(func() {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+
+			if c.users--; c.users == 0 && c.retired {
+				go c.db.Close()
+			}
+		})
+	}
+}
+
+func (r *registry) exists(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, ok := r.sources[id]
+	return ok
+}
+
+// get возвращает подключение к источнику и функцию его освобождения, которую вызывающий обязан вызвать,
+// когда закончит работу с подключением. При необходимости get дожидается подключения.
+func (r *registry) get(id string) (*database.Database, func(), error) {
+	r.mu.Lock()
+
+	e, ok := r.sources[id]
+	if !ok {
+		r.mu.Unlock()
+		return nil, nil, fmt.Errorf("отсутствует источник %s", id)
+	}
+
+	switch {
+	case e.state == entity.SourceConnected:
+		defer r.mu.Unlock()
+		return e.conn.db, r.acquire(e.conn), nil
+
+	case e.state == entity.SourceFailed && time.Now().Before(e.retryAt):
+		err := e.lastErr
+		r.mu.Unlock()
+		return nil, nil, fmt.Errorf("источник %s недоступен: %s", id, err.Error())
+	}
+
+	done := r.start(id, e)
+	r.mu.Unlock()
+
+	<-done
+
+	r.mu.Lock()
+
+	if e.state != entity.SourceConnected {
+		err := e.lastErr
+		r.mu.Unlock()
+		return nil, nil, fmt.Errorf("источник %s недоступен: %s", id, err.Error())
+	}
+
+	//источник изменили сразу после подключения, подключение уже закрывается.
+	if e.conn.retired {
+		r.mu.Unlock()
+		return r.get(id)
+	}
+
+	defer r.mu.Unlock()
+
+	return e.conn.db, r.acquire(e.conn), nil
+}
+
+// start начинает подключение, если оно еще не начато, и возвращает канал его окончания.
+// Вызывается под r.mu.
+func (r *registry) start(id string, e *registered) chan struct{} {
+	if e.state == entity.SourceConnecting {
+		return e.done
+	}
+
+	e.state, e.done = entity.SourceConnecting, make(chan struct{})
+
+	source, done := e.source, e.done
+
+	go func() {
+		db, err := r.connect(id, source)
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		defer close(done)
+
+		//источник удален или заменен, пока шло подключение.
+		if r.sources[id] != e {
+			if db != nil {
+				_ = db.Close()
+			}
+			e.state, e.lastErr = entity.SourceFailed, fmt.Errorf("источник изменен во время подключения")
+			return
+		}
+
+		if err != nil {
+			delay := minReconnectDelay << e.retries
+			if delay > maxReconnectDelay || delay <= 0 {
+				delay = maxReconnectDelay
+			}
+
+			e.state, e.lastErr, e.retryAt = entity.SourceFailed, err, time.Now().Add(delay)
+			e.retries++
+
+			return
+		}
+
+		e.state, e.conn, e.lastErr, e.retries = entity.SourceConnected, &conn{db: db}, nil, 0
+	}()
+
+	return done
+}
+
+// connectAll начинает подключение всех еще не подключенных источников, не дожидаясь его окончания.
+func (r *registry) connectAll() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, e := range r.sources {
+		if e.state == entity.SourceIdle {
+			r.start(id, e)
+		}
+	}
+}
+
+// run повторяет подключение источников после неудачи, пока не отменен ctx.
+func (r *registry) run(ctx context.Context) {
+	ticker := time.NewTicker(minReconnectDelay)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+
+		r.mu.Lock()
+		for id, e := range r.sources {
+			if e.state == entity.SourceFailed && now.After(e.retryAt) {
+				r.start(id, e)
+			}
+		}
+		r.mu.Unlock()
+	}
+}
+
+// state возвращает состояние подключения и последнюю ошибку источника.
+func (r *registry) state(id string) (string, string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e, ok := r.sources[id]
+	if !ok {
+		return entity.SourceIdle, ""
+	}
+
+	if e.lastErr != nil {
+		return e.state, e.lastErr.Error()
+	}
+
+	return e.state, ""
+}
+
+// connected возвращает идентификаторы подключенных источников.
+func (r *registry) connected() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ids := make([]string, 0, len(r.sources))
+	for id, e := range r.sources {
+		if e.state == entity.SourceConnected {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids
+}
@@ -0,0 +1,108 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"datapointbackend/internal/entity"
+	"datapointbackend/pkg/database"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// closeDialer отмечает закрытие подключения, которому принадлежит.
+type closeDialer struct {
+	closed chan struct{}
+}
+
+func (d *closeDialer) DialContext(context.Context, string, string) (net.Conn, error) {
+	return nil, errors.New("not dialable")
+}
+
+func (d *closeDialer) Close() error {
+	close(d.closed)
+	return nil
+}
+
+func testDatabase(t *testing.T) (*database.Database, chan struct{}) {
+	t.Helper()
+
+	conn, err := sql.Open("postgres", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := &closeDialer{closed: make(chan struct{})}
+
+	return &database.Database{Conn: conn, Config: database.Config{Dialer: d}}, d.closed
+}
+
+func isClosed(closed chan struct{}) bool {
+	select {
+	case <-closed:
+		return true
+	case <-time.After(100 * time.Millisecond):
+		return false
+	}
+}
+
+func TestRegistryDrain(t *testing.T) {
+	r := newRegistry(nil)
+
+	old, oldClosed := testDatabase(t)
+	r.add("s", entity.Source{}, old)
+
+	db, release, err := r.get("s")
+	if err != nil || db != old {
+		t.Fatalf("get = %v, %v", db, err)
+	}
+
+	//источник изменен, пока запрос еще исполняется.
+	next, nextClosed := testDatabase(t)
+	r.add("s", entity.Source{}, next)
+
+	if isClosed(oldClosed) {
+		t.Fatal("replaced connection closed while in use")
+	}
+
+	if db, releaseNext, _ := r.get("s"); db != next {
+		t.Fatal("get after replace returned the old connection")
+	} else {
+		releaseNext()
+	}
+
+	release()
+	//повторное освобождение ничего не меняет.
+	release()
+
+	if !isClosed(oldClosed) {
+		t.Fatal("replaced connection is not closed after release")
+	}
+
+	r.remove("s")
+
+	if !isClosed(nextClosed) {
+		t.Fatal("removed connection without users is not closed")
+	}
+}
+
+func TestRegistryState(t *testing.T) {
+	r := newRegistry(func(string, entity.Source) (*database.Database, error) {
+		return nil, errors.New("connection refused")
+	})
+
+	r.add("s", entity.Source{}, nil)
+
+	if state, _ := r.state("s"); state != entity.SourceIdle {
+		t.Fatalf("state = %s, want %s", state, entity.SourceIdle)
+	}
+
+	if _, _, err := r.get("s"); err == nil {
+		t.Fatal("get of a failed source succeeded")
+	}
+
+	if state, lastErr := r.state("s"); state != entity.SourceFailed || lastErr != "connection refused" {
+		t.Fatalf("state = %s, %q", state, lastErr)
+	}
+}
@@ -0,0 +1,107 @@
+package service
+
+import (
+	"context"
+	"datapointbackend/pkg/cache"
+	"datapointbackend/pkg/database"
+	"time"
+)
+
+// schemaCache хранит сведения о таблицах источников, чтобы не читать каталог базы данных на каждый запрос.
+type schemaCache struct {
+	c   *cache.Cache
+	ttl time.Duration
+}
+
+// schemaSnapshot - все таблицы источника на момент чтения каталога.
+type schemaSnapshot struct {
+	Tables      []*database.Table `json:"tables"`
+	RefreshedAt time.Time         `json:"refreshedAt"`
+}
+
+func newSchemaCache(storage cache.Storage, ttl time.Duration) *schemaCache {
+	return &schemaCache{c: cache.NewCache(storage, "schema"), ttl: ttl}
+}
+
+// tables возвращает все таблицы источника, при промахе читает каталог.
+func (sc *schemaCache) tables(ctx context.Context, sourceId string, db *database.Database) (schemaSnapshot, error) {
+	var snapshot schemaSnapshot
+
+	if sc.c.Get(ctx, "tables:"+sourceId, &snapshot) {
+		return snapshot, nil
+	}
+
+	return sc.refresh(ctx, sourceId, db)
+}
+
+// refresh перечитывает каталог источника и заменяет им кэш.
+func (sc *schemaCache) refresh(ctx context.Context, sourceId string, db *database.Database) (schemaSnapshot, error) {
+	tables, err := db.GetTables(ctx)
+	if err != nil {
+		return schemaSnapshot{}, err
+	}
+
+	snapshot := schemaSnapshot{Tables: tables, RefreshedAt: time.Now()}
+
+	if err = sc.purge(ctx, sourceId); err != nil {
+		return snapshot, nil
+	}
+
+	//ошибки хранилища учитываются в статистике кэша, каталог уже прочитан.
+	_ = sc.c.Set(ctx, "tables:"+sourceId, snapshot, sc.ttl)
+
+	for _, table := range tables {
+		_ = sc.c.Set(ctx, tableCacheKey(sourceId, table.Name), table, sc.ttl)
+	}
+
+	return snapshot, nil
+}
+
+// refreshedAt возвращает время последнего чтения всего каталога источника, nil - каталог не в кэше.
+func (sc *schemaCache) refreshedAt(ctx context.Context, sourceId string) *time.Time {
+	var snapshot schemaSnapshot
+
+	if !sc.c.Get(ctx, "tables:"+sourceId, &snapshot) {
+		return nil
+	}
+
+	return &snapshot.RefreshedAt
+}
+
+func (sc *schemaCache) purge(ctx context.Context, sourceId string) error {
+	if _, err := sc.c.Purge(ctx, "tables:"+sourceId); err != nil {
+		return err
+	}
+
+	_, err := sc.c.Purge(ctx, "table:"+sourceId+":")
+
+	return err
+}
+
+func tableCacheKey(sourceId, name string) string {
+	return "table:" + sourceId + ":" + name
+}
+
+// sourceSchema - сведения о таблицах одного источника, которые использует database.Database при разборе запросов.
+type sourceSchema struct {
+	sc       *schemaCache
+	sourceId string
+	db       *database.Database
+}
+
+func (s *sourceSchema) GetTable(ctx context.Context, name string) (*database.Table, error) {
+	var table database.Table
+
+	if s.sc.c.Get(ctx, tableCacheKey(s.sourceId, name), &table) {
+		return &table, nil
+	}
+
+	t, err := s.db.GetTable(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	_ = s.sc.c.Set(ctx, tableCacheKey(s.sourceId, name), t, s.sc.ttl)
+
+	return t, nil
+}
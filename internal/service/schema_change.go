@@ -0,0 +1,289 @@
+package service
+
+import (
+	"context"
+	"datapointbackend/internal/entity"
+	"datapointbackend/pkg/database"
+	"fmt"
+	"time"
+)
+
+type schemaRepository interface {
+	GetLast(ctx context.Context, sourceId string, before time.Time, limit uint64) ([]entity.SchemaSnapshot, error)
+	Create(ctx context.Context, s entity.SchemaSnapshot) (entity.SchemaSnapshot, error)
+}
+
+type widgetLister interface {
+	GetAll(ctx context.Context) ([]entity.Widget, error)
+}
+
+// SchemaService сохраняет снимки схем источников и сообщает, какие виджеты затронуты их изменениями.
+type SchemaService struct {
+	ss  *SourceService
+	scr schemaRepository
+	wl  widgetLister
+}
+
+func NewSchemaService(ss *SourceService, scr schemaRepository, wl widgetLister) *SchemaService {
+	return &SchemaService{ss: ss, scr: scr, wl: wl}
+}
+
+// Run сохраняет снимки схем всех источников каждые interval, пока не отменен ctx.
+func (s *SchemaService) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for _, id := range s.ss.sourceIds() {
+			//недоступный источник будет снят при следующем запуске.
+			_, _ = s.Capture(ctx, id)
+		}
+	}
+}
+
+// Capture читает схему источника и сохраняет снимок, если она отличается от последнего снимка.
+func (s *SchemaService) Capture(ctx context.Context, sourceId string) (entity.SchemaSnapshot, error) {
+	current, err := s.ss.refreshSchema(ctx, sourceId)
+	if err != nil {
+		return entity.SchemaSnapshot{}, err
+	}
+
+	var last []entity.SchemaSnapshot
+
+	if last, err = s.scr.GetLast(ctx, sourceId, time.Now(), 1); err != nil {
+		return entity.SchemaSnapshot{}, fmt.Errorf("не удалось получить снимок схемы: %s", err.Error())
+	}
+
+	if len(last) != 0 && len(diffSchema(last[0].Tables, current.Tables)) == 0 {
+		return last[0], nil
+	}
+
+	var snapshot entity.SchemaSnapshot
+
+	if snapshot, err = s.scr.Create(ctx, entity.SchemaSnapshot{
+		SourceId: sourceId,
+		Tables:   current.Tables,
+	}); err != nil {
+		return entity.SchemaSnapshot{}, fmt.Errorf("не удалось сохранить снимок схемы: %s", err.Error())
+	}
+
+	return snapshot, nil
+}
+
+// GetChanges сравнивает текущую схему источника со снимком на момент since,
+// а если он не указан, то с предыдущим снимком.
+func (s *SchemaService) GetChanges(ctx context.Context, sourceId string, since *time.Time) (entity.SchemaChanges, error) {
+	current, err := s.Capture(ctx, sourceId)
+	if err != nil {
+		return entity.SchemaChanges{}, err
+	}
+
+	changes := entity.SchemaChanges{
+		SourceId: sourceId,
+		To:       current.CreatedAt,
+		Changes:  make([]entity.SchemaChange, 0),
+		Impact:   make([]entity.WidgetImpact, 0),
+	}
+
+	var (
+		before = current.CreatedAt
+		limit  = uint64(2)
+	)
+
+	if since != nil {
+		before, limit = *since, 1
+	}
+
+	var sl []entity.SchemaSnapshot
+
+	if sl, err = s.scr.GetLast(ctx, sourceId, before, limit); err != nil {
+		return entity.SchemaChanges{}, fmt.Errorf("не удалось получить снимок схемы: %s", err.Error())
+	}
+
+	if uint64(len(sl)) < limit {
+		return changes, nil
+	}
+
+	baseline := sl[limit-1]
+
+	changes.From = &baseline.CreatedAt
+	changes.Changes = diffSchema(baseline.Tables, current.Tables)
+
+	if len(changes.Changes) == 0 {
+		return changes, nil
+	}
+
+	var widgets []entity.Widget
+
+	if widgets, err = s.wl.GetAll(ctx); err != nil {
+		return entity.SchemaChanges{}, fmt.Errorf("не удалось получить виджеты: %s", err.Error())
+	}
+
+	walkWidgets(widgets, func(w *entity.Widget) {
+		if affected := affectedBy(w, sourceId, changes.Changes); len(affected) != 0 {
+			changes.Impact = append(changes.Impact, entity.WidgetImpact{
+				WidgetId:   w.Id,
+				WidgetName: w.Name,
+				Changes:    affected,
+			})
+		}
+	})
+
+	return changes, nil
+}
+
+// diffSchema возвращает изменения таблиц и столбцов между двумя снимками.
+func diffSchema(old, new []*database.Table) []entity.SchemaChange {
+	var (
+		changes  = make([]entity.SchemaChange, 0)
+		oldIndex = tableIndex(old)
+		newIndex = tableIndex(new)
+	)
+
+	for _, ot := range old {
+		nt, ok := newIndex[ot.Name]
+		if !ok {
+			changes = append(changes, entity.SchemaChange{Kind: entity.TableRemoved, Table: ot.Name})
+			continue
+		}
+
+		newColumns := columnIndex(nt)
+
+		for _, oc := range ot.Columns {
+			nc, ok := newColumns[oc.Name]
+			switch {
+			case !ok:
+				changes = append(changes, entity.SchemaChange{
+					Kind: entity.ColumnRemoved, Table: ot.Name, Column: oc.Name, OldType: oc.DataType,
+				})
+			case oc.DataType != nc.DataType:
+				changes = append(changes, entity.SchemaChange{
+					Kind: entity.ColumnRetyped, Table: ot.Name, Column: oc.Name, OldType: oc.DataType, NewType: nc.DataType,
+				})
+			}
+		}
+
+		oldColumns := columnIndex(ot)
+
+		for _, nc := range nt.Columns {
+			if _, ok := oldColumns[nc.Name]; !ok {
+				changes = append(changes, entity.SchemaChange{
+					Kind: entity.ColumnAdded, Table: nt.Name, Column: nc.Name, NewType: nc.DataType,
+				})
+			}
+		}
+	}
+
+	for _, nt := range new {
+		if _, ok := oldIndex[nt.Name]; !ok {
+			changes = append(changes, entity.SchemaChange{Kind: entity.TableAdded, Table: nt.Name})
+		}
+	}
+
+	return changes
+}
+
+func tableIndex(tables []*database.Table) map[string]*database.Table {
+	index := make(map[string]*database.Table, len(tables))
+	for _, t := range tables {
+		index[t.Name] = t
+	}
+	return index
+}
+
+func columnIndex(t *database.Table) map[string]database.Column {
+	index := make(map[string]database.Column, len(t.Columns))
+	for _, c := range t.Columns {
+		index[c.Name] = c
+	}
+	return index
+}
+
+// walkWidgets вызывает fn для каждого виджета и всех его потомков.
+func walkWidgets(widgets []entity.Widget, fn func(w *entity.Widget)) {
+	var walk func(w *entity.Widget)
+	walk = func(w *entity.Widget) {
+		fn(w)
+		for _, child := range w.Children {
+			walk(child)
+		}
+	}
+
+	for i := range widgets {
+		walk(&widgets[i])
+	}
+}
+
+// affectedBy возвращает удаления и изменения типов, которые касаются таблиц и столбцов запроса виджета.
+func affectedBy(w *entity.Widget, sourceId string, changes []entity.SchemaChange) []entity.SchemaChange {
+	if w.Query == nil || w.Query.SourceId != sourceId || w.Query.Table == nil {
+		return nil
+	}
+
+	refs := queryRefs(w.Query.Query)
+
+	var affected []entity.SchemaChange
+
+	for _, c := range changes {
+		switch c.Kind {
+		case entity.TableRemoved:
+			if _, ok := refs[c.Table]; ok {
+				affected = append(affected, c)
+			}
+		case entity.ColumnRemoved, entity.ColumnRetyped:
+			if refs[c.Table][c.Column] {
+				affected = append(affected, c)
+			}
+		}
+	}
+
+	return affected
+}
+
+// queryRefs возвращает столбцы, на которые ссылается запрос, по именам таблиц.
+func queryRefs(query database.Query) map[string]map[string]bool {
+	refs := make(map[string]map[string]bool)
+
+	add := func(columns ...*database.QColumn) {
+		for _, c := range columns {
+			if c == nil {
+				continue
+			}
+			if refs[c.TableKey.Name] == nil {
+				refs[c.TableKey.Name] = make(map[string]bool)
+			}
+			refs[c.TableKey.Name][c.Name] = true
+		}
+	}
+
+	var walk func(t *database.QTable)
+	walk = func(t *database.QTable) {
+		if refs[t.Name] == nil {
+			refs[t.Name] = make(map[string]bool)
+		}
+
+		if t.Rule != nil {
+			for _, condition := range t.Rule.Conditions {
+				add(condition.Columns[:]...)
+			}
+		}
+
+		for _, next := range t.Next {
+			walk(next)
+		}
+	}
+
+	walk(query.Table)
+
+	add(query.Columns...)
+	add(query.Where...)
+	add(query.OrderBy...)
+
+	return refs
+}
@@ -3,8 +3,11 @@ package service
 import (
 	"context"
 	"datapointbackend/internal/entity"
+	"datapointbackend/pkg/cache"
 	"datapointbackend/pkg/database"
+	"errors"
 	"fmt"
+	"time"
 )
 
 type sourceRepository interface {
@@ -17,21 +20,67 @@ type sourceRepository interface {
 
 type SourceService struct {
 	sr      sourceRepository
-	sources map[string]*database.Database
+	sources *registry
+	limits  database.Limits //общие для всех источников ограничения результата.
+	schema  *schemaCache
+	results *resultCache
 }
 
-func NewSourceService(sr sourceRepository) *SourceService {
-	s := SourceService{sources: make(map[string]*database.Database), sr: sr}
+func NewSourceService(
+	sr sourceRepository,
+	limits database.Limits,
+	storage cache.Storage,
+	schemaTTL time.Duration,
+) *SourceService {
+	s := SourceService{
+		sr:      sr,
+		limits:  limits,
+		schema:  newSchemaCache(storage, schemaTTL),
+		results: newResultCache(storage),
+	}
+
+	s.sources = newRegistry(s.connect)
 
 	sl, _ := s.sr.GetAll(context.Background())
 
 	for _, source := range sl {
-		s.sources[source.Id], _ = database.New(source.Config)
+		s.sources.add(source.Id, source, nil)
 	}
 
+	//запуск не ждет недоступные источники.
+	s.sources.connectAll()
+
 	return &s
 }
 
+// Run повторяет подключение к недоступным источникам, пока не отменен ctx.
+func (s *SourceService) Run(ctx context.Context) {
+	s.sources.run(ctx)
+}
+
+// connect подключается к источнику с учетом общих ограничений и кэша схемы.
+// SSH-туннель источника закрывается вместе с подключением.
+func (s *SourceService) connect(id string, source entity.Source) (*database.Database, error) {
+	cfg := source.Config
+	cfg.Limits = cfg.Limits.Merge(s.limits)
+
+	if source.Tunnel != nil {
+		cfg.Dialer = newTunnel(*source.Tunnel)
+	}
+
+	db, err := database.New(cfg)
+	if err != nil {
+		if t, ok := cfg.Dialer.(*tunnel); ok {
+			_ = t.Close()
+		}
+		return nil, err
+	}
+
+	db.Schema = &sourceSchema{sc: s.schema, sourceId: id, db: db}
+
+	return db, nil
+}
+
 func (s *SourceService) GetAll(ctx context.Context) ([]entity.Source, error) {
 	sl, err := s.sr.GetAll(ctx)
 	if err != nil {
@@ -39,7 +88,10 @@ func (s *SourceService) GetAll(ctx context.Context) ([]entity.Source, error) {
 	}
 
 	for i := range sl {
-		sl[i].Connected = s.IsConnected(sl[i].Id)
+		sl[i].State, sl[i].LastError = s.sources.state(sl[i].Id)
+		sl[i].Connected = sl[i].State == entity.SourceConnected
+		sl[i].SchemaRefreshedAt = s.schema.refreshedAt(ctx, sl[i].Id)
+		sl[i].Redact()
 	}
 
 	return sl, nil
@@ -51,113 +103,231 @@ func (s *SourceService) GetOne(ctx context.Context, id string) (entity.Source, e
 		return entity.Source{}, fmt.Errorf("%s", err.Error())
 	}
 
-	source.Connected = s.IsConnected(source.Id)
+	source.State, source.LastError = s.sources.state(source.Id)
+	source.Connected = source.State == entity.SourceConnected
+	source.SchemaRefreshedAt = s.schema.refreshedAt(ctx, source.Id)
+	source.Redact()
 
 	return source, nil
 }
 
-// Edit - предполагается, что схема базы данных не изменится.
+// Edit - изменения схемы базы данных отслеживает SchemaService.
 func (s *SourceService) Edit(ctx context.Context, source entity.Source) error {
-	db, err := s.GetDatabase(source.Id)
-	if err != nil {
-		return err
+	//недоступный источник тоже можно исправить.
+	if !s.sources.exists(source.Id) {
+		return fmt.Errorf("отсутствует источник %s", source.Id)
 	}
 
-	var newDb *database.Database
+	if err := s.unmask(ctx, &source); err != nil {
+		return err
+	}
 
-	if newDb, err = database.New(source.Config); err != nil {
+	db, err := s.connect(source.Id, source)
+	if err != nil {
 		return fmt.Errorf("не удалось подключиться к источнику: %s", err.Error())
 	}
 
 	if err = s.sr.Edit(ctx, source); err != nil {
-		_ = newDb.Conn.Close()
+		_ = db.Close()
 		return fmt.Errorf("не удалось отредактировать источник: %s", err.Error())
 	}
 
-	_ = db.Conn.Close()
+	s.sources.add(source.Id, source, db)
 
-	s.sources[source.Id] = newDb
+	//источник мог начать указывать на другую базу данных.
+	s.purge(ctx, source.Id)
 
 	return nil
 }
 
 func (s *SourceService) Delete(ctx context.Context, id string) error {
-	db, err := s.GetDatabase(id)
-	if err != nil {
-		return err
+	if !s.sources.exists(id) {
+		return fmt.Errorf("отсутствует источник %s", id)
 	}
 
-	if err = s.sr.Delete(ctx, id); err != nil {
+	if err := s.sr.Delete(ctx, id); err != nil {
 		return fmt.Errorf("не удалось удалить источник: %s", err.Error())
 	}
 
-	_ = db.Conn.Close()
+	s.sources.remove(id)
 
-	delete(s.sources, id)
+	s.purge(ctx, id)
 
 	return nil
 }
 
+// purge удаляет кэшированные схему и результаты источника.
+func (s *SourceService) purge(ctx context.Context, id string) {
+	_ = s.schema.purge(ctx, id)
+	_, _ = s.results.purge(ctx, id)
+}
+
 func (s *SourceService) Create(ctx context.Context, source entity.Source) (string, error) {
-	db, err := database.New(source.Config)
+	db, err := s.connect("", source)
 	if err != nil {
 		return "", fmt.Errorf("не удалось подключиться к источнику: %s", err.Error())
 	}
 
 	if source.Id, err = s.sr.Create(ctx, source); err != nil {
-		_ = db.Conn.Close()
+		_ = db.Close()
 		return "", fmt.Errorf("не удалось сохранить конфигурацию источника: %s", err.Error())
 	}
 
-	s.sources[source.Id] = db
+	//идентификатор известен только после сохранения.
+	db.Schema = &sourceSchema{sc: s.schema, sourceId: source.Id, db: db}
+
+	s.sources.add(source.Id, source, db)
 
 	return source.Id, nil
 }
 
+// Test проверяет подключение к источнику по шагам, не сохраняя его.
+func (s *SourceService) Test(ctx context.Context, source entity.Source) database.Diagnosis {
+	//проверка сохраненного источника с неизмененными секретами.
+	if len(source.Id) != 0 {
+		if err := s.unmask(ctx, &source); err != nil {
+			return database.Rejected("введите секреты источника заново", err)
+		}
+	}
+
+	if source.Tunnel != nil {
+		t := newTunnel(*source.Tunnel)
+		defer func() { _ = t.Close() }()
+
+		source.Dialer = t
+	}
+
+	return database.Diagnose(ctx, source.Config)
+}
+
+// unmask заменяет секреты, пришедшие как SecretMask, сохраненными значениями.
+// Сохраненные секреты подставляются, только если источник указывает туда же, куда сохраненный,
+// иначе их можно было бы отправить на сервер, выбранный вызывающим.
+func (s *SourceService) unmask(ctx context.Context, source *entity.Source) error {
+	secrets := source.Secrets()
+
+	masked := false
+	for _, secret := range secrets {
+		masked = masked || *secret == entity.SecretMask
+	}
+
+	if !masked {
+		return nil
+	}
+
+	stored, err := s.sr.GetOne(ctx, source.Id)
+	if err != nil {
+		return fmt.Errorf("не удалось получить сохраненный источник: %s", err.Error())
+	}
+
+	if !sameEndpoint(stored, *source) {
+		return errors.New("адрес, пользователь или база данных источника изменены, сохраненные секреты не подставляются")
+	}
+
+	storedSecrets := stored.Secrets()
+
+	for i, secret := range secrets {
+		if *secret == entity.SecretMask {
+			*secret = *storedSecrets[i]
+		}
+	}
+
+	return nil
+}
+
+// sameEndpoint проверяет, что источники подключаются к одному серверу под одним пользователем,
+// в том числе через один SSH-хост.
+func sameEndpoint(a, b entity.Source) bool {
+	if a.Host != b.Host || a.Port != b.Port || a.Username != b.Username || a.DatabaseName != b.DatabaseName {
+		return false
+	}
+
+	if a.Tunnel == nil || b.Tunnel == nil {
+		return a.Tunnel == nil && b.Tunnel == nil
+	}
+
+	return a.Tunnel.Host == b.Tunnel.Host && a.Tunnel.Port == b.Tunnel.Port && a.Tunnel.User == b.Tunnel.User
+}
+
 func (s *SourceService) GetDrivers() []string {
 	return []string{database.PostgreSQL}
 }
 
 func (s *SourceService) GetTables(ctx context.Context, id string) ([]*database.Table, error) {
-	db, err := s.GetDatabase(id)
+	db, release, err := s.GetDatabase(id)
 	if err != nil {
 		return nil, err
 	}
+	defer release()
 
-	var tables []*database.Table
-	if tables, err = db.GetTables(ctx); err != nil {
+	var snapshot schemaSnapshot
+	if snapshot, err = s.schema.tables(ctx, id, db); err != nil {
 		return nil, fmt.Errorf("произошла ошибка при получении таблиц %s", err.Error())
 	}
 
-	return tables, nil
+	return snapshot.Tables, nil
 }
 
-func (s *SourceService) GetDatabase(id string) (*database.Database, error) {
-	source, ok := s.sources[id]
-	if !ok {
-		return nil, fmt.Errorf("отсутствует источник %s", id)
+// RefreshSchema перечитывает таблицы источника, не дожидаясь истечения срока хранения кэша,
+// и возвращает время чтения.
+func (s *SourceService) RefreshSchema(ctx context.Context, id string) (time.Time, error) {
+	snapshot, err := s.refreshSchema(ctx, id)
+	if err != nil {
+		return time.Time{}, err
 	}
-	return source, nil
+
+	return snapshot.RefreshedAt, nil
 }
 
-func (s *SourceService) IsConnected(id string) bool {
-	db, err := s.GetDatabase(id)
+func (s *SourceService) refreshSchema(ctx context.Context, id string) (schemaSnapshot, error) {
+	db, release, err := s.GetDatabase(id)
 	if err != nil {
-		return false
+		return schemaSnapshot{}, err
 	}
+	defer release()
 
-	if err = db.Conn.Ping(); err != nil {
-		return false
+	var snapshot schemaSnapshot
+	if snapshot, err = s.schema.refresh(ctx, id, db); err != nil {
+		return schemaSnapshot{}, fmt.Errorf("не удалось обновить схему источника: %s", err.Error())
+	}
+
+	return snapshot, nil
+}
+
+// SchemaCacheStats возвращает счетчики попаданий в кэш схемы.
+func (s *SourceService) SchemaCacheStats() cache.Stats {
+	return s.schema.c.Stats()
+}
+
+// GetDatabase возвращает подключение к источнику, подключаясь при первом обращении, и функцию его освобождения.
+// Вызывающий обязан освободить подключение: после изменения источника старое подключение закрывается,
+// только когда его освободят все.
+func (s *SourceService) GetDatabase(id string) (*database.Database, func(), error) {
+	return s.sources.get(id)
+}
+
+// sourceIds возвращает идентификаторы источников, к которым удалось подключиться.
+func (s *SourceService) sourceIds() []string {
+	return s.sources.connected()
+}
+
+// GetStats возвращает состояние пула подключений и задержку запросов источника.
+func (s *SourceService) GetStats(id string) (database.Stats, error) {
+	db, release, err := s.GetDatabase(id)
+	if err != nil {
+		return database.Stats{}, err
 	}
+	defer release()
 
-	return true
+	return db.Stats(), nil
 }
 
 func (s *SourceService) GetFunctions(id string) (map[string][]string, error) {
-	db, err := s.GetDatabase(id)
+	db, release, err := s.GetDatabase(id)
 	if err != nil {
 		return nil, err
 	}
+	defer release()
 
 	return db.GetFunctions()
 }
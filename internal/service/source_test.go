@@ -0,0 +1,273 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"datapointbackend/internal/entity"
+	"datapointbackend/pkg/cache"
+	"datapointbackend/pkg/database"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// sourceStore - сохраненные источники по идентификаторам.
+type sourceStore map[string]entity.Source
+
+func (s sourceStore) GetAll(context.Context) ([]entity.Source, error) {
+	sl := make([]entity.Source, 0, len(s))
+	for _, source := range s {
+		sl = append(sl, source)
+	}
+	return sl, nil
+}
+
+func (s sourceStore) GetOne(_ context.Context, id string) (entity.Source, error) {
+	source, ok := s[id]
+	if !ok {
+		return entity.Source{}, errors.New("not found")
+	}
+	return source, nil
+}
+
+func (s sourceStore) Edit(_ context.Context, source entity.Source) error {
+	s[source.Id] = source
+	return nil
+}
+
+func (s sourceStore) Delete(_ context.Context, id string) error {
+	delete(s, id)
+	return nil
+}
+
+func (s sourceStore) Create(_ context.Context, source entity.Source) (string, error) {
+	s[source.Id] = source
+	return source.Id, nil
+}
+
+func storedSource() entity.Source {
+	return entity.Source{
+		Id: "s",
+		Config: database.Config{
+			Host:         "db.internal",
+			Port:         5432,
+			Username:     "app",
+			Password:     "db-secret",
+			DatabaseName: "app",
+			Driver:       database.PostgreSQL,
+		},
+		Tunnel: &entity.Tunnel{Host: "bastion.internal", User: "app", Password: "ssh-secret"},
+	}
+}
+
+func TestUnmask(t *testing.T) {
+	s := &SourceService{sr: sourceStore{"s": storedSource()}}
+
+	source := storedSource()
+	source.Redact()
+	source.Name = "renamed"
+
+	if err := s.unmask(context.Background(), &source); err != nil {
+		t.Fatal(err)
+	}
+
+	if source.Password != "db-secret" || source.Tunnel.Password != "ssh-secret" {
+		t.Errorf("secrets = %q, %q", source.Password, source.Tunnel.Password)
+	}
+
+	for name, change := range map[string]func(s *entity.Source){
+		"host":          func(s *entity.Source) { s.Host = "attacker.example" },
+		"port":          func(s *entity.Source) { s.Port = 5433 },
+		"username":      func(s *entity.Source) { s.Username = "postgres" },
+		"database":      func(s *entity.Source) { s.DatabaseName = "postgres" },
+		"tunnel host":   func(s *entity.Source) { s.Tunnel.Host = "attacker.example" },
+		"tunnel user":   func(s *entity.Source) { s.Tunnel.User = "root" },
+		"tunnel port":   func(s *entity.Source) { s.Tunnel.Port = 2222 },
+		"tunnel remove": func(s *entity.Source) { s.Tunnel = nil },
+	} {
+		source := storedSource()
+		source.Redact()
+		change(&source)
+
+		if err := s.unmask(context.Background(), &source); err == nil {
+			t.Errorf("%s changed: unmask = nil, password %q", name, source.Password)
+		}
+	}
+}
+
+func TestTestChangedHost(t *testing.T) {
+	//сервер вызывающего, которому не должен уйти сохраненный пароль.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = listener.Close() }()
+
+	dialed := make(chan struct{}, 1)
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			dialed <- struct{}{}
+			_ = conn.Close()
+		}
+	}()
+
+	stored := storedSource()
+	stored.Tunnel = nil
+
+	r := newRegistry(nil)
+	r.add(stored.Id, stored, nil)
+
+	s := &SourceService{sr: sourceStore{stored.Id: stored}, sources: r}
+
+	addr := listener.Addr().(*net.TCPAddr)
+
+	source := stored
+	source.Redact()
+	source.Host, source.Port = addr.IP.String(), addr.Port
+
+	if d := s.Test(context.Background(), source); d.Ok || d.Steps[0].Status != database.StepFailed {
+		t.Errorf("Test = %+v, want rejected", d)
+	}
+
+	if err = s.Edit(context.Background(), source); err == nil {
+		t.Error("Edit accepted a masked password for another host")
+	}
+
+	select {
+	case <-dialed:
+		t.Fatal("masked password sent to a changed host")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// servePostgres запускает сервер, который принимает любого пользователя и отвечает на запросы пустым результатом,
+// и возвращает его адрес.
+func servePostgres(t *testing.T) *net.TCPAddr {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+
+	message := func(kind byte, body ...byte) []byte {
+		m := append([]byte{kind}, binary.BigEndian.AppendUint32(nil, uint32(4+len(body)))...)
+		return append(m, body...)
+	}
+
+	ready := message('Z', 'I')
+
+	serve := func(conn net.Conn) {
+		defer func() { _ = conn.Close() }()
+
+		r := bufio.NewReader(conn)
+
+		//стартовое сообщение без типа.
+		var size uint32
+		if binary.Read(r, binary.BigEndian, &size) != nil {
+			return
+		}
+		if _, err := io.CopyN(io.Discard, r, int64(size)-4); err != nil {
+			return
+		}
+
+		if _, err := conn.Write(append(message('R', 0, 0, 0, 0), ready...)); err != nil {
+			return
+		}
+
+		for {
+			kind, err := r.ReadByte()
+			if err != nil || kind == 'X' {
+				return
+			}
+
+			if binary.Read(r, binary.BigEndian, &size) != nil {
+				return
+			}
+			if _, err = io.CopyN(io.Discard, r, int64(size)-4); err != nil {
+				return
+			}
+
+			if kind == 'Q' {
+				if _, err = conn.Write(append(message('I'), ready...)); err != nil {
+					return
+				}
+			}
+		}
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serve(conn)
+		}
+	}()
+
+	return listener.Addr().(*net.TCPAddr)
+}
+
+func TestEditPurgesResults(t *testing.T) {
+	ctx := context.Background()
+
+	addr := servePostgres(t)
+
+	stored := storedSource()
+	stored.Tunnel = nil
+	stored.Host, stored.Port = addr.IP.String(), addr.Port
+
+	storage := cache.NewMemory(0, 0)
+
+	s := &SourceService{
+		sr:      sourceStore{stored.Id: stored},
+		sources: newRegistry(nil),
+		schema:  newSchemaCache(storage, time.Minute),
+		results: newResultCache(storage),
+	}
+	s.sources.add(stored.Id, stored, nil)
+
+	cached := func(key string) bool {
+		var v string
+		return s.results.c.Get(ctx, key, &v)
+	}
+
+	for _, key := range []string{"data:s:old", "data:other:old"} {
+		if err := s.results.c.Set(ctx, key, "rows", 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	//источник указывает на другую базу данных.
+	edited := stored
+	edited.DatabaseName = "other"
+
+	if err := s.Edit(ctx, edited); err != nil {
+		t.Fatal(err)
+	}
+
+	if cached("data:s:old") {
+		t.Error("result of the old database survived Edit")
+	}
+
+	if !cached("data:other:old") {
+		t.Error("Edit purged results of another source")
+	}
+
+	if err := s.results.c.Set(ctx, "data:s:new", "rows", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Delete(ctx, stored.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	if cached("data:s:new") {
+		t.Error("result survived Delete")
+	}
+}
@@ -0,0 +1,398 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"datapointbackend/internal/entity"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	tunnelDialTimeout = 15 * time.Second
+	tunnelKeepAlive   = 30 * time.Second
+)
+
+// tunnel - SSH-туннель к промежуточному хосту, через который подключается источник.
+// Подключение к хосту устанавливается при первом обращении и восстанавливается после обрыва.
+type tunnel struct {
+	cfg entity.Tunnel
+
+	mu     sync.Mutex
+	client *ssh.Client
+	closed bool
+}
+
+func newTunnel(cfg entity.Tunnel) *tunnel {
+	return &tunnel{cfg: cfg}
+}
+
+// DialContext открывает через туннель соединение с address, адрес разрешается на промежуточном хосте.
+func (t *tunnel) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	client, err := t.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := client.DialContext(ctx, network, address)
+
+	//отказ промежуточного хоста открыть соединение не связан с обрывом туннеля.
+	var openErr *ssh.OpenChannelError
+	if err == nil || errors.As(err, &openErr) || ctx.Err() != nil {
+		return conn, err
+	}
+
+	//туннель мог оборваться незаметно, поэтому попытка повторяется через новое подключение.
+	t.drop(client)
+
+	if client, err = t.connect(ctx); err != nil {
+		return nil, err
+	}
+
+	return client.DialContext(ctx, network, address)
+}
+
+func (t *tunnel) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.closed = true
+
+	if t.client == nil {
+		return nil
+	}
+
+	err := t.client.Close()
+	t.client = nil
+
+	return err
+}
+
+// connect возвращает подключение к промежуточному хосту, при необходимости подключаясь заново.
+// Подключение идет без блокировки, чтобы Close и другие обращения не ждали его окончания.
+func (t *tunnel) connect(ctx context.Context) (*ssh.Client, error) {
+	t.mu.Lock()
+	closed, client := t.closed, t.client
+	t.mu.Unlock()
+
+	if closed {
+		return nil, errors.New("SSH-туннель закрыт")
+	}
+
+	if client != nil {
+		return client, nil
+	}
+
+	client, err := t.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		_ = client.Close()
+		return nil, errors.New("SSH-туннель закрыт")
+	}
+
+	//одновременное обращение подключилось раньше.
+	if t.client != nil {
+		_ = client.Close()
+		return t.client, nil
+	}
+
+	t.client = client
+
+	go t.watch(client)
+
+	return client, nil
+}
+
+// dial подключается к промежуточному хосту.
+func (t *tunnel) dial(ctx context.Context) (*ssh.Client, error) {
+	config, err := t.config()
+	if err != nil {
+		return nil, err
+	}
+
+	port := t.cfg.Port
+	if port == 0 {
+		port = 22
+	}
+
+	address := net.JoinHostPort(t.cfg.Host, strconv.Itoa(port))
+
+	ctx, cancel := context.WithTimeout(ctx, tunnelDialTimeout)
+	defer cancel()
+
+	var (
+		dialer net.Dialer
+		conn   net.Conn
+	)
+
+	if conn, err = dialer.DialContext(ctx, "tcp", address); err != nil {
+		return nil, fmt.Errorf("не удалось подключиться к SSH-хосту %s: %s", address, err.Error())
+	}
+
+	//рукопожатие SSH не принимает ctx.
+	deadline, _ := ctx.Deadline()
+	_ = conn.SetDeadline(deadline)
+
+	c, chans, reqs, err := ssh.NewClientConn(conn, address, config)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("не удалось установить SSH-соединение с %s: %s", address, err.Error())
+	}
+
+	_ = conn.SetDeadline(time.Time{})
+
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// watch проверяет соединение с промежуточным хостом и сбрасывает его при обрыве,
+// чтобы следующее обращение подключилось заново.
+func (t *tunnel) watch(client *ssh.Client) {
+	closed := make(chan struct{})
+
+	go func() {
+		_ = client.Wait()
+		close(closed)
+	}()
+
+	ticker := time.NewTicker(tunnelKeepAlive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-closed:
+			t.drop(client)
+			return
+		case <-ticker.C:
+		}
+
+		if _, _, err := client.SendRequest("keepalive@openssh.com", true, nil); err != nil {
+			t.drop(client)
+			return
+		}
+	}
+}
+
+// drop закрывает client, если он все еще текущее подключение туннеля.
+func (t *tunnel) drop(client *ssh.Client) {
+	t.mu.Lock()
+	if t.client == client {
+		t.client = nil
+	}
+	t.mu.Unlock()
+
+	_ = client.Close()
+}
+
+func (t *tunnel) config() (*ssh.ClientConfig, error) {
+	if len(t.cfg.KnownHosts) == 0 {
+		return nil, errors.New("не указан ключ SSH-хоста в knownHosts")
+	}
+
+	hostKey, err := knownHostsCallback(t.cfg.KnownHosts)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось прочитать knownHosts: %s", err.Error())
+	}
+
+	config := ssh.ClientConfig{
+		User:            t.cfg.User,
+		HostKeyCallback: hostKey,
+		Timeout:         tunnelDialTimeout,
+	}
+
+	if len(t.cfg.PrivateKey) != 0 {
+		var signer ssh.Signer
+
+		if len(t.cfg.Passphrase) != 0 {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase([]byte(t.cfg.PrivateKey), []byte(t.cfg.Passphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey([]byte(t.cfg.PrivateKey))
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("не удалось прочитать закрытый ключ SSH: %s", err.Error())
+		}
+
+		config.Auth = append(config.Auth, ssh.PublicKeys(signer))
+	}
+
+	if len(t.cfg.Password) != 0 {
+		config.Auth = append(config.Auth, ssh.Password(t.cfg.Password))
+	}
+
+	if len(config.Auth) == 0 {
+		return nil, errors.New("для SSH-туннеля нужен пароль или закрытый ключ")
+	}
+
+	return &config, nil
+}
+
+// knownHost - строка known_hosts.
+type knownHost struct {
+	revoked  bool
+	patterns []string
+	key      ssh.PublicKey
+}
+
+// knownHostsCallback проверяет ключ хоста по строкам known_hosts, разобранным в памяти.
+// Поддерживаются имена и адреса с портом, шаблоны с * и ?, исключения с !, хэшированные имена
+// и отзыв ключей через @revoked. Строки @cert-authority не поддерживаются и пропускаются.
+func knownHostsCallback(knownHosts string) (ssh.HostKeyCallback, error) {
+	var (
+		hosts []knownHost
+		rest  = []byte(knownHosts)
+	)
+
+	for {
+		marker, patterns, key, _, next, err := ssh.ParseKnownHosts(rest)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		rest = next
+
+		if marker == "" || marker == "revoked" {
+			hosts = append(hosts, knownHost{revoked: marker == "revoked", patterns: patterns, key: key})
+		}
+	}
+
+	if len(hosts) == 0 {
+		return nil, errors.New("нет ключей хостов")
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		addresses := []string{knownhosts.Normalize(hostname)}
+		if remote != nil {
+			addresses = append(addresses, knownhosts.Normalize(remote.String()))
+		}
+
+		known := false
+
+		for _, h := range hosts {
+			if h.revoked {
+				if bytes.Equal(h.key.Marshal(), key.Marshal()) {
+					return fmt.Errorf("ключ SSH-хоста %s отозван", hostname)
+				}
+				continue
+			}
+
+			if !known && h.match(addresses) && bytes.Equal(h.key.Marshal(), key.Marshal()) {
+				known = true
+			}
+		}
+
+		if !known {
+			return fmt.Errorf("ключ SSH-хоста %s не найден в knownHosts", hostname)
+		}
+
+		return nil
+	}, nil
+}
+
+// match проверяет, относится ли строка к одному из адресов.
+func (h knownHost) match(addresses []string) bool {
+	for _, address := range addresses {
+		matched := false
+
+		for _, pattern := range h.patterns {
+			negated := strings.HasPrefix(pattern, "!")
+
+			if !matchHost(strings.TrimPrefix(pattern, "!"), address) {
+				continue
+			}
+
+			//исключение отменяет строку для адреса, даже если он подходит под другой шаблон.
+			if negated {
+				matched = false
+				break
+			}
+
+			matched = true
+		}
+
+		if matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchHost сравнивает адрес с шаблоном known_hosts: хэшем |1|соль|хэш или именем с * и ?.
+func matchHost(pattern, address string) bool {
+	if strings.HasPrefix(pattern, "|1|") {
+		parts := strings.Split(pattern[3:], "|")
+		if len(parts) != 2 {
+			return false
+		}
+
+		salt, err := base64.StdEncoding.DecodeString(parts[0])
+		if err != nil {
+			return false
+		}
+
+		hash, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			return false
+		}
+
+		mac := hmac.New(sha1.New, salt)
+		mac.Write([]byte(address))
+
+		return hmac.Equal(mac.Sum(nil), hash)
+	}
+
+	return matchWildcard(pattern, address)
+}
+
+// matchWildcard сравнивает строку с шаблоном, где * - любая последовательность символов, а ? - один символ.
+// При несовпадении возвращается к последней * и пробует отдать ей на символ больше,
+// поэтому время линейно по длине шаблона, умноженной на длину строки.
+func matchWildcard(pattern, s string) bool {
+	p, i := 0, 0
+	star, next := -1, 0 //позиция последней * в шаблоне и позиция строки, с которой ее пробовать дальше.
+
+	for i < len(s) {
+		switch {
+		case p < len(pattern) && pattern[p] == '*':
+			star, next = p, i
+			p++
+
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == s[i]):
+			p++
+			i++
+
+		case star != -1:
+			next++
+			p, i = star+1, next
+
+		default:
+			return false
+		}
+	}
+
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+
+	return p == len(pattern)
+}
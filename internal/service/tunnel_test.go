@@ -0,0 +1,414 @@
+package service
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"datapointbackend/internal/entity"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func newHostKey(t *testing.T) ssh.Signer {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signer
+}
+
+// listen запускает TCP-сервер на свободном порту и обрабатывает подключения в handle.
+func listen(t *testing.T, handle func(conn net.Conn)) net.Listener {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go handle(conn)
+		}
+	}()
+
+	return l
+}
+
+// serveSSH запускает SSH-сервер с паролем password, который пересылает каналы direct-tcpip
+// на запрошенный адрес, и возвращает его слушатель.
+func serveSSH(t *testing.T, hostKey ssh.Signer, password string) net.Listener {
+	t.Helper()
+
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(_ ssh.ConnMetadata, p []byte) (*ssh.Permissions, error) {
+			if string(p) != password {
+				return nil, fmt.Errorf("wrong password")
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	return listen(t, func(conn net.Conn) {
+		_, chans, reqs, err := ssh.NewServerConn(conn, config)
+		if err != nil {
+			_ = conn.Close()
+			return
+		}
+
+		go ssh.DiscardRequests(reqs)
+
+		for ch := range chans {
+			if ch.ChannelType() != "direct-tcpip" {
+				_ = ch.Reject(ssh.UnknownChannelType, "unsupported")
+				continue
+			}
+
+			var target struct {
+				Host     string
+				Port     uint32
+				OrigHost string
+				OrigPort uint32
+			}
+
+			if err = ssh.Unmarshal(ch.ExtraData(), &target); err != nil {
+				_ = ch.Reject(ssh.ConnectionFailed, err.Error())
+				continue
+			}
+
+			upstream, err := net.Dial("tcp", net.JoinHostPort(target.Host, strconv.Itoa(int(target.Port))))
+			if err != nil {
+				_ = ch.Reject(ssh.ConnectionFailed, err.Error())
+				continue
+			}
+
+			channel, requests, err := ch.Accept()
+			if err != nil {
+				_ = upstream.Close()
+				continue
+			}
+
+			go ssh.DiscardRequests(requests)
+
+			go func() {
+				_, _ = io.Copy(upstream, channel)
+				_ = upstream.Close()
+			}()
+
+			go func() {
+				_, _ = io.Copy(channel, upstream)
+				_ = channel.Close()
+			}()
+		}
+	})
+}
+
+// serveEcho запускает сервер, который возвращает полученные данные.
+func serveEcho(t *testing.T) string {
+	t.Helper()
+
+	return listen(t, func(conn net.Conn) {
+		defer conn.Close()
+		_, _ = io.Copy(conn, conn)
+	}).Addr().String()
+}
+
+func tunnelConfig(t *testing.T, l net.Listener, hostKey ssh.PublicKey) entity.Tunnel {
+	t.Helper()
+
+	host, port, err := net.SplitHostPort(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, _ := strconv.Atoi(port)
+
+	return entity.Tunnel{
+		Host:       host,
+		Port:       p,
+		User:       "datapoint",
+		Password:   "secret",
+		KnownHosts: knownhosts.Line([]string{l.Addr().String()}, hostKey),
+	}
+}
+
+func echo(t *testing.T, conn net.Conn, message string) {
+	t.Helper()
+
+	if _, err := conn.Write([]byte(message)); err != nil {
+		t.Fatal(err)
+	}
+
+	reply := make([]byte, len(message))
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		t.Fatal(err)
+	}
+
+	if string(reply) != message {
+		t.Fatalf("reply = %q, want %q", reply, message)
+	}
+}
+
+func TestTunnelDial(t *testing.T) {
+	hostKey := newHostKey(t)
+
+	tun := newTunnel(tunnelConfig(t, serveSSH(t, hostKey, "secret"), hostKey.PublicKey()))
+	defer tun.Close()
+
+	target := serveEcho(t)
+
+	conn, err := tun.DialContext(context.Background(), "tcp", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	echo(t, conn, "ping")
+
+	//второе соединение идет через то же подключение к хосту.
+	client := tun.client
+
+	second, err := tun.DialContext(context.Background(), "tcp", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+
+	if tun.client != client {
+		t.Error("second dial reconnected to the SSH host")
+	}
+
+	echo(t, second, "pong")
+}
+
+func TestTunnelReconnect(t *testing.T) {
+	hostKey := newHostKey(t)
+
+	tun := newTunnel(tunnelConfig(t, serveSSH(t, hostKey, "secret"), hostKey.PublicKey()))
+	defer tun.Close()
+
+	target := serveEcho(t)
+
+	conn, err := tun.DialContext(context.Background(), "tcp", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.Close()
+
+	//обрыв подключения к хосту.
+	_ = tun.client.Close()
+
+	if conn, err = tun.DialContext(context.Background(), "tcp", target); err != nil {
+		t.Fatalf("dial after the SSH connection broke: %v", err)
+	}
+	defer conn.Close()
+
+	echo(t, conn, "again")
+}
+
+func TestTunnelRejects(t *testing.T) {
+	hostKey := newHostKey(t)
+
+	l := serveSSH(t, hostKey, "secret")
+
+	tests := []struct {
+		name    string
+		change  func(cfg *entity.Tunnel)
+		wantErr string
+	}{
+		{
+			name: "unknown host key",
+			change: func(cfg *entity.Tunnel) {
+				cfg.KnownHosts = knownhosts.Line([]string{l.Addr().String()}, newHostKey(t).PublicKey())
+			},
+			wantErr: "не найден в knownHosts",
+		},
+		{
+			name: "revoked host key",
+			change: func(cfg *entity.Tunnel) {
+				cfg.KnownHosts = "@revoked * " + strings.TrimSpace(string(ssh.MarshalAuthorizedKey(hostKey.PublicKey())))
+			},
+			wantErr: "отозван",
+		},
+		{
+			name:    "wrong password",
+			change:  func(cfg *entity.Tunnel) { cfg.Password = "wrong" },
+			wantErr: "unable to authenticate",
+		},
+		{
+			name:    "no known hosts",
+			change:  func(cfg *entity.Tunnel) { cfg.KnownHosts = "" },
+			wantErr: "knownHosts",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tunnelConfig(t, l, hostKey.PublicKey())
+			tt.change(&cfg)
+
+			tun := newTunnel(cfg)
+			defer tun.Close()
+
+			_, err := tun.DialContext(context.Background(), "tcp", serveEcho(t))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// подключение к хосту, который не отвечает, не блокирует закрытие туннеля.
+func TestTunnelCloseWhileConnecting(t *testing.T) {
+	hostKey := newHostKey(t)
+
+	silent := listen(t, func(conn net.Conn) {
+		time.Sleep(time.Second)
+		_ = conn.Close()
+	})
+
+	tun := newTunnel(tunnelConfig(t, silent, hostKey.PublicKey()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := tun.DialContext(ctx, "tcp", "127.0.0.1:1")
+		done <- err
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+	_ = tun.Close()
+
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("Close waited %s for the dial", elapsed)
+	}
+
+	if err := <-done; err == nil {
+		t.Fatal("dial succeeded")
+	}
+
+	if tun.client != nil {
+		t.Error("closed tunnel kept a client")
+	}
+}
+
+func TestKnownHostsCallback(t *testing.T) {
+	key, other := newHostKey(t).PublicKey(), newHostKey(t).PublicKey()
+
+	authorized := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+
+	tests := []struct {
+		name       string
+		knownHosts string
+		hostname   string
+		key        ssh.PublicKey
+		ok         bool
+	}{
+		{"name", "bastion " + authorized, "bastion:22", key, true},
+		{"other key", "bastion " + authorized, "bastion:22", other, false},
+		{"other host", "bastion " + authorized, "gateway:22", key, false},
+		{"port", "[bastion]:2222 " + authorized, "bastion:2222", key, true},
+		{"default port only", "bastion " + authorized, "bastion:2222", key, false},
+		{"wildcard", "*.example.com " + authorized, "db.example.com:22", key, true},
+		{"question mark", "db?.example.com " + authorized, "db1.example.com:22", key, true},
+		{"negated", "*.example.com,!db.example.com " + authorized, "db.example.com:22", key, false},
+		{"hashed", knownhosts.HashHostname("bastion") + " " + authorized, "bastion:22", key, true},
+		{"hashed other host", knownhosts.HashHostname("bastion") + " " + authorized, "gateway:22", key, false},
+		{"hashed port", knownhosts.HashHostname("[bastion]:2222") + " " + authorized, "bastion:2222", key, true},
+		{"hashed other port", knownhosts.HashHostname("[bastion]:2222") + " " + authorized, "bastion:22", key, false},
+		{"hashed without port", knownhosts.HashHostname("bastion") + " " + authorized, "bastion:2222", key, false},
+		{"wildcard port", "[*.example.com]:2222 " + authorized, "db.example.com:2222", key, true},
+		{"negated wildcard", "*.example.com,!db*.example.com " + authorized, "db1.example.com:22", key, false},
+		{"negated wildcard other", "*.example.com,!db*.example.com " + authorized, "web.example.com:22", key, true},
+		{"negated first", "!*.internal.example.com,*.example.com " + authorized, "db.internal.example.com:22", key, false},
+		{"negated hashed", "*.example.com,!" + knownhosts.HashHostname("db.example.com") + " " + authorized, "db.example.com:22", key, false},
+		{"comments", "# bastion\n\nbastion " + authorized + " comment", "bastion:22", key, true},
+		{"revoked", "bastion " + authorized + "\n@revoked * " + authorized, "bastion:22", key, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			callback, err := knownHostsCallback(tt.knownHosts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err = callback(tt.hostname, nil, tt.key); (err == nil) != tt.ok {
+				t.Fatalf("err = %v, want ok = %v", err, tt.ok)
+			}
+		})
+	}
+
+	if _, err := knownHostsCallback("# only comments\n"); err == nil {
+		t.Error("known hosts without keys accepted")
+	}
+}
+
+func TestMatchWildcard(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		ok         bool
+	}{
+		{"", "", true},
+		{"", "a", false},
+		{"*", "", true},
+		{"*", "bastion", true},
+		{"?", "", false},
+		{"bastion", "bastion", true},
+		{"bastion", "bastion2", false},
+		{"b*n", "bastion", true},
+		{"b*n", "bastions", false},
+		{"*.example.com", "db.example.com", true},
+		{"*.example.com", "example.com", false},
+		{"db?.*.com", "db1.example.com", true},
+		{"a*b*c", "aXbYbZc", true},
+		{"a*b*c", "aXbYbZ", false},
+		{"**a", "a", true},
+	}
+
+	for _, tt := range tests {
+		if ok := matchWildcard(tt.pattern, tt.s); ok != tt.ok {
+			t.Errorf("matchWildcard(%q, %q) = %v, want %v", tt.pattern, tt.s, ok, tt.ok)
+		}
+	}
+
+	//шаблон из многих * не должен перебирать все разбиения длинного имени.
+	pattern := strings.Repeat("*a", 30) + "*b"
+	s := strings.Repeat("a", 10000)
+
+	start := time.Now()
+
+	if matchWildcard(pattern, s) {
+		t.Error("pattern without b matched")
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("matchWildcard took %s", elapsed)
+	}
+}
@@ -3,7 +3,10 @@ package service
 import (
 	"context"
 	"datapointbackend/internal/entity"
+	"datapointbackend/pkg/database"
 	"fmt"
+	"sync"
+	"time"
 )
 
 type widgetRepository interface {
@@ -16,10 +19,35 @@ type widgetRepository interface {
 
 type WidgetService struct {
 	wr widgetRepository
+	ss *SourceService
+	qs *QueryService
+
+	concurrency   int           //одновременных запросов виджетов к одному источнику.
+	widgetTimeout time.Duration //0 - без ограничений.
+
+	mu      sync.Mutex
+	sources map[string]chan struct{} //семафоры запросов виджетов по источникам.
 }
 
-func NewWidgetService(wr widgetRepository) *WidgetService {
-	return &WidgetService{wr: wr}
+func NewWidgetService(
+	wr widgetRepository,
+	ss *SourceService,
+	qs *QueryService,
+	concurrency int,
+	widgetTimeout time.Duration,
+) *WidgetService {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	return &WidgetService{
+		wr:            wr,
+		ss:            ss,
+		qs:            qs,
+		concurrency:   concurrency,
+		widgetTimeout: widgetTimeout,
+		sources:       make(map[string]chan struct{}),
+	}
 }
 
 func (s *WidgetService) GetAll(ctx context.Context) ([]entity.Widget, error) {
@@ -46,6 +74,10 @@ func (s *WidgetService) Delete(ctx context.Context, id string) error {
 }
 
 func (s *WidgetService) Create(ctx context.Context, w entity.Widget) (string, error) {
+	if err := s.validate(ctx, w); err != nil {
+		return "", err
+	}
+
 	id, err := s.wr.Create(ctx, w, nil)
 	if err != nil {
 		return "", fmt.Errorf("не удалось сохранить виджет: %s", err.Error())
@@ -54,9 +86,41 @@ func (s *WidgetService) Create(ctx context.Context, w entity.Widget) (string, er
 }
 
 func (s *WidgetService) Edit(ctx context.Context, w entity.Widget) error {
+	if err := s.validate(ctx, w); err != nil {
+		return err
+	}
+
 	if err := s.wr.Edit(ctx, w); err != nil {
 		return err
 	}
 
 	return nil
 }
+
+// validate проверяет запросы виджета и его потомков по схемам их источников.
+func (s *WidgetService) validate(ctx context.Context, w entity.Widget) error {
+	var err error
+
+	walkWidgets([]entity.Widget{w}, func(w *entity.Widget) {
+		if err != nil || w.Query == nil {
+			return
+		}
+
+		var (
+			db      *database.Database
+			release func()
+		)
+
+		if db, release, err = s.ss.GetDatabase(w.Query.SourceId); err != nil {
+			err = fmt.Errorf("не удалось проверить запрос виджета %s: %s", w.Name, err.Error())
+			return
+		}
+		defer release()
+
+		if err = db.Validate(ctx, w.Query.Query); err != nil {
+			err = fmt.Errorf("неверный запрос виджета %s: %s", w.Name, err.Error())
+		}
+	})
+
+	return err
+}
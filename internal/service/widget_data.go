@@ -0,0 +1,206 @@
+package service
+
+import (
+	"context"
+	"datapointbackend/internal/entity"
+	"datapointbackend/pkg/database"
+	"fmt"
+	"sync"
+)
+
+// GetData исполняет сохраненные запросы виджета и его потомков с изменениями input
+// и возвращает результаты по идентификаторам виджетов, виджеты без запроса пропускаются.
+func (s *WidgetService) GetData(
+	ctx context.Context,
+	id string,
+	input entity.WidgetInput,
+) (map[string]database.QResponse, error) {
+	w, err := s.wr.GetOne(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make(map[string]database.QResponse)
+
+	s.run(ctx, []entity.Widget{w}, func(string) entity.WidgetInput { return input }, func(d entity.WidgetData) {
+		data[d.WidgetId] = d.QResponse
+	})
+
+	return data, nil
+}
+
+// run параллельно исполняет запросы виджетов и их потомков с изменениями input по идентификатору виджета
+// и передает каждый результат в emit, как только он готов. Одновременно к одному источнику исполняется
+// не больше concurrency запросов, а ошибка или истечение времени запроса одного виджета не влияют на остальные.
+// emit не вызывается одновременно из нескольких горутин.
+func (s *WidgetService) run(
+	ctx context.Context,
+	widgets []entity.Widget,
+	input func(id string) entity.WidgetInput,
+	emit func(d entity.WidgetData),
+) {
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+	)
+
+	walkWidgets(widgets, func(w *entity.Widget) {
+		if len(w.QueryError) != 0 {
+			mu.Lock()
+			defer mu.Unlock()
+
+			emit(entity.WidgetData{
+				WidgetId:  w.Id,
+				QResponse: database.QResponse{}.Errorf("не удалось прочитать сохраненный запрос виджета: %s", w.QueryError),
+			})
+
+			return
+		}
+
+		if w.Query == nil {
+			return
+		}
+
+		wg.Add(1)
+
+		go func(id string, query entity.Query) {
+			defer wg.Done()
+
+			response := s.executeLimited(ctx, query, input(id))
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			emit(entity.WidgetData{WidgetId: id, QResponse: response})
+		}(w.Id, *w.Query)
+	})
+
+	wg.Wait()
+}
+
+// executeLimited исполняет запрос виджета, когда у источника освобождается место,
+// но не дольше widgetTimeout вместе с ожиданием.
+func (s *WidgetService) executeLimited(ctx context.Context, query entity.Query, input entity.WidgetInput) database.QResponse {
+	if s.widgetTimeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.widgetTimeout)
+		defer cancel()
+	}
+
+	slots := s.slots(query.SourceId)
+
+	select {
+	case slots <- struct{}{}:
+		defer func() { <-slots }()
+	case <-ctx.Done():
+		return database.QResponse{}.Errorf("запрос виджета не дождался очереди к источнику: %s", ctx.Err().Error())
+	}
+
+	return s.execute(ctx, query, input)
+}
+
+// slots возвращает семафор одновременных запросов виджетов к источнику.
+func (s *WidgetService) slots(sourceId string) chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	slots, ok := s.sources[sourceId]
+	if !ok {
+		slots = make(chan struct{}, s.concurrency)
+		s.sources[sourceId] = slots
+	}
+
+	return slots
+}
+
+// execute исполняет сохраненный запрос виджета с изменениями input.
+func (s *WidgetService) execute(ctx context.Context, query entity.Query, input entity.WidgetInput) database.QResponse {
+	//сохраненный запрос исполняется при каждом просмотре, поэтому изменять данные он не должен.
+	if query.Type != database.Select {
+		return database.QResponse{}.Errorf("данные есть только у виджетов с запросом select")
+	}
+
+	db, release, err := s.ss.GetDatabase(query.SourceId)
+	if err != nil {
+		return database.QResponse{}.Errorf(err.Error())
+	}
+	defer release()
+
+	//параметры подставляются до фильтров, чтобы значения фильтров не считались ссылками на параметры.
+	if query.Query, err = query.Bind(input.Values); err != nil {
+		return database.QResponse{}.Errorf("не удалось подставить параметры запроса: %s", err.Error())
+	}
+
+	if query.Query, err = applyInput(query.Query, input); err != nil {
+		return database.QResponse{}.Errorf(err.Error())
+	}
+
+	//условия пришли от клиента, поэтому столбцы проверяются по схеме.
+	if err = db.Validate(ctx, query.Query); err != nil {
+		return database.QResponse{}.Errorf("неверный запрос виджета: %s", err.Error())
+	}
+
+	//параметры уже подставлены, повторно они не подставляются.
+	return s.qs.executeBound(ctx, db, query)
+}
+
+// applyInput возвращает копию запроса с изменениями input.
+func applyInput(query database.Query, input entity.WidgetInput) (database.Query, error) {
+	tables := make(map[database.QTableKey]bool)
+
+	var walk func(t *database.QTable)
+	walk = func(t *database.QTable) {
+		tables[t.QTableKey] = true
+		for _, next := range t.Next {
+			walk(next)
+		}
+	}
+
+	if query.Table != nil {
+		walk(query.Table)
+	}
+
+	//срез сохраненного запроса не должен меняться.
+	query.Where = query.Where[:len(query.Where):len(query.Where)]
+
+	for _, f := range input.Filters {
+		if f == nil {
+			return query, fmt.Errorf("пустое условие в фильтрах")
+		}
+
+		if !tables[f.TableKey] {
+			continue
+		}
+
+		//из условия берутся только столбец, значение и оператор.
+		column := database.QColumn{
+			Column:   database.Column{Name: f.Name},
+			TableKey: f.TableKey,
+			Value:    f.Value,
+		}
+
+		if op, ok := f.Payload[database.Operator]; ok {
+			column.Payload = map[string]any{database.Operator: op}
+		}
+
+		query.Where = append(query.Where, &column)
+	}
+
+	if input.Limit != nil {
+		query.Limit = *input.Limit
+	}
+
+	if input.Offset != nil {
+		query.Offset = *input.Offset
+	}
+
+	if len(input.Cursor) != 0 {
+		query.Cursor = input.Cursor
+	}
+
+	if len(input.TimeZone) != 0 {
+		query.TimeZone = input.TimeZone
+	}
+
+	return query, nil
+}
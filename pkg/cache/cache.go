@@ -0,0 +1,186 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/vmihailenco/msgpack/v5"
+	"reflect"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	Memory = "memory"
+	Redis  = "redis"
+)
+
+// Storage - хранилище сериализованных значений кэша.
+type Storage interface {
+	// Get возвращает значение по ключу, false - значения нет или срок его хранения истек.
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	// Set сохраняет значение на время ttl, 0 - бессрочно.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Incr увеличивает счетчик на 1 и возвращает новое значение, счетчики хранятся бессрочно.
+	Incr(ctx context.Context, key string) (int64, error)
+	// Purge удаляет значения, ключи которых начинаются с prefix, и возвращает их количество.
+	Purge(ctx context.Context, prefix string) (int, error)
+	Close() error
+}
+
+type Config struct {
+	Driver     string      `yaml:"driver"`      //memory или redis, по умолчанию memory.
+	MaxBytes   int64       `yaml:"max_bytes"`   //только для memory, 0 - без ограничений.
+	MaxEntries int         `yaml:"max_entries"` //только для memory, 0 - без ограничений.
+	Redis      RedisConfig `yaml:"redis"`
+}
+
+type RedisConfig struct {
+	Addr     string `yaml:"addr"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	DB       int    `yaml:"db"`
+	Prefix   string `yaml:"prefix"` //общий префикс ключей, чтобы разделять экземпляры с одним сервером Redis.
+}
+
+// New создает хранилище по конфигурации.
+func New(cfg Config) (Storage, error) {
+	switch cfg.Driver {
+	case "", Memory:
+		return NewMemory(cfg.MaxBytes, cfg.MaxEntries), nil
+	case Redis:
+		return NewRedis(cfg.Redis)
+
+	default:
+		return nil, fmt.Errorf("неизвестное хранилище кэша %s", cfg.Driver)
+	}
+}
+
+// jsonNumberExt - тип расширения msgpack для json.Number, иначе число превратится в строку.
+const jsonNumberExt = 1
+
+func init() {
+	msgpack.RegisterExtEncoder(jsonNumberExt, json.Number(""),
+		func(e *msgpack.Encoder, v reflect.Value) ([]byte, error) {
+			return []byte(v.String()), nil
+		},
+	)
+
+	msgpack.RegisterExtDecoder(jsonNumberExt, json.Number(""),
+		func(d *msgpack.Decoder, v reflect.Value, extLen int) error {
+			b := make([]byte, extLen)
+			if err := d.ReadFull(b); err != nil {
+				return err
+			}
+			v.SetString(string(b))
+			return nil
+		},
+	)
+}
+
+// Stats - счетчики обращений к кэшу.
+type Stats struct {
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+	Errors uint64 `json:"errors"` //ошибки хранилища, при чтении считаются и промахом.
+}
+
+// Cache хранит значения в Storage в формате msgpack и считает попадания.
+// Ошибки хранилища не прерывают работу: кэш без хранилища - это всегда промах.
+type Cache struct {
+	storage Storage
+	prefix  string
+
+	hits, misses, errors atomic.Uint64
+}
+
+// NewCache создает кэш с ключами, начинающимися с prefix, чтобы разные кэши не пересекались в одном хранилище.
+func NewCache(storage Storage, prefix string) *Cache {
+	return &Cache{storage: storage, prefix: prefix + ":"}
+}
+
+// Get читает значение в v и возвращает true при попадании.
+func (c *Cache) Get(ctx context.Context, key string, v any) bool {
+	data, ok, err := c.storage.Get(ctx, c.prefix+key)
+	if err == nil && ok {
+		err = c.unmarshal(data, v)
+	}
+
+	if err != nil {
+		c.errors.Add(1)
+		ok = false
+	}
+
+	if ok {
+		c.hits.Add(1)
+	} else {
+		c.misses.Add(1)
+	}
+
+	return ok
+}
+
+func (c *Cache) Set(ctx context.Context, key string, v any, ttl time.Duration) error {
+	data, err := c.marshal(v)
+	if err == nil {
+		err = c.storage.Set(ctx, c.prefix+key, data, ttl)
+	}
+
+	if err != nil {
+		c.errors.Add(1)
+		return fmt.Errorf("не удалось сохранить значение в кэш: %s", err.Error())
+	}
+
+	return nil
+}
+
+// Counter возвращает значение счетчика, 0 - счетчик не создан. Обращения к счетчикам не учитываются в Stats.
+func (c *Cache) Counter(ctx context.Context, key string) (int64, error) {
+	data, ok, err := c.storage.Get(ctx, c.prefix+key)
+	if err != nil || !ok {
+		return 0, err
+	}
+
+	return strconv.ParseInt(string(data), 10, 64)
+}
+
+func (c *Cache) Incr(ctx context.Context, key string) (int64, error) {
+	return c.storage.Incr(ctx, c.prefix+key)
+}
+
+// Purge удаляет значения, ключи которых начинаются с prefix.
+func (c *Cache) Purge(ctx context.Context, prefix string) (int, error) {
+	return c.storage.Purge(ctx, c.prefix+prefix)
+}
+
+func (c *Cache) Stats() Stats {
+	return Stats{
+		Hits:   c.hits.Load(),
+		Misses: c.misses.Load(),
+		Errors: c.errors.Load(),
+	}
+}
+
+// marshal использует имена из тегов json, чтобы прочитанные в any значения совпадали с ответами API.
+func (c *Cache) marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+
+	e := msgpack.NewEncoder(&buf)
+	e.SetCustomStructTag("json")
+	e.UseCompactInts(true)
+
+	if err := e.Encode(v); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (c *Cache) unmarshal(data []byte, v any) error {
+	d := msgpack.NewDecoder(bytes.NewReader(data))
+	d.SetCustomStructTag("json")
+
+	return d.Decode(v)
+}
@@ -0,0 +1,180 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// storageCase - хранилище и способ продвинуть его время.
+type storageCase struct {
+	name    string
+	storage Storage
+	advance func(d time.Duration)
+}
+
+func storages(t *testing.T) []storageCase {
+	t.Helper()
+
+	m := miniredis.RunT(t)
+
+	redis, err := NewRedis(RedisConfig{Addr: m.Addr(), Prefix: "dp:"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = redis.Close() })
+
+	return []storageCase{
+		{name: Memory, storage: NewMemory(0, 0), advance: func(d time.Duration) { time.Sleep(d) }},
+		{name: Redis, storage: redis, advance: m.FastForward},
+	}
+}
+
+func TestStorageTTL(t *testing.T) {
+	ctx := context.Background()
+
+	for _, sc := range storages(t) {
+		t.Run(sc.name, func(t *testing.T) {
+			if err := sc.storage.Set(ctx, "forever", []byte("a"), 0); err != nil {
+				t.Fatal(err)
+			}
+			if err := sc.storage.Set(ctx, "short", []byte("b"), 20*time.Millisecond); err != nil {
+				t.Fatal(err)
+			}
+
+			if value, ok, err := sc.storage.Get(ctx, "short"); err != nil || !ok || string(value) != "b" {
+				t.Fatalf("short before expiry = %q, %v, %v", value, ok, err)
+			}
+
+			sc.advance(50 * time.Millisecond)
+
+			if _, ok, err := sc.storage.Get(ctx, "short"); err != nil || ok {
+				t.Errorf("short after expiry: ok = %v, err = %v, want miss", ok, err)
+			}
+
+			//ttl 0 - бессрочно в обоих хранилищах.
+			if value, ok, err := sc.storage.Get(ctx, "forever"); err != nil || !ok || string(value) != "a" {
+				t.Errorf("forever = %q, %v, %v, want a", value, ok, err)
+			}
+		})
+	}
+}
+
+func TestStorageIncr(t *testing.T) {
+	ctx := context.Background()
+
+	for _, sc := range storages(t) {
+		t.Run(sc.name, func(t *testing.T) {
+			for want := int64(1); want <= 3; want++ {
+				if got, err := sc.storage.Incr(ctx, "counter"); err != nil || got != want {
+					t.Fatalf("Incr = %d, %v, want %d", got, err, want)
+				}
+			}
+
+			if value, ok, err := sc.storage.Get(ctx, "counter"); err != nil || !ok || string(value) != "3" {
+				t.Errorf("Get counter = %q, %v, %v, want 3", value, ok, err)
+			}
+		})
+	}
+}
+
+func TestStoragePurge(t *testing.T) {
+	ctx := context.Background()
+
+	for _, sc := range storages(t) {
+		t.Run(sc.name, func(t *testing.T) {
+			for _, key := range []string{"data:1:a", "data:1:b", "data:2:a", "data:[1]:a", "other"} {
+				if err := sc.storage.Set(ctx, key, []byte(key), time.Minute); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if count, err := sc.storage.Purge(ctx, "data:1:"); err != nil || count != 2 {
+				t.Fatalf("Purge data:1: = %d, %v, want 2", count, err)
+			}
+
+			//специальные символы шаблона SCAN не расширяют префикс.
+			if count, err := sc.storage.Purge(ctx, "data:[1]:"); err != nil || count != 1 {
+				t.Fatalf("Purge data:[1]: = %d, %v, want 1", count, err)
+			}
+
+			for key, want := range map[string]bool{"data:1:a": false, "data:1:b": false, "data:2:a": true, "other": true} {
+				if _, ok, _ := sc.storage.Get(ctx, key); ok != want {
+					t.Errorf("%s found = %v, want %v", key, ok, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRedisPrefix(t *testing.T) {
+	m := miniredis.RunT(t)
+
+	s, err := NewRedis(RedisConfig{Addr: m.Addr(), Prefix: "dp:"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if err = s.Set(context.Background(), "key", []byte("value"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := m.Get("dp:key"); err != nil || got != "value" {
+		t.Errorf("dp:key = %q, %v, want value", got, err)
+	}
+
+	if m.TTL("dp:key") != 0 {
+		t.Errorf("dp:key ttl = %s, want none", m.TTL("dp:key"))
+	}
+}
+
+func TestMemoryEviction(t *testing.T) {
+	ctx := context.Background()
+
+	s := NewMemory(0, 2)
+
+	for _, key := range []string{"a", "b"} {
+		_ = s.Set(ctx, key, []byte(key), 0)
+	}
+
+	//a использован недавно, поэтому вытесняется b.
+	_, _, _ = s.Get(ctx, "a")
+	_ = s.Set(ctx, "c", []byte("c"), 0)
+
+	for key, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		if _, ok, _ := s.Get(ctx, key); ok != want {
+			t.Errorf("%s found = %v, want %v", key, ok, want)
+		}
+	}
+}
+
+func TestCache(t *testing.T) {
+	ctx := context.Background()
+
+	for _, sc := range storages(t) {
+		t.Run(sc.name, func(t *testing.T) {
+			c := NewCache(sc.storage, "test")
+
+			type value struct {
+				Name  string
+				Count int
+			}
+
+			if err := c.Set(ctx, "key", value{Name: "a", Count: 2}, 0); err != nil {
+				t.Fatal(err)
+			}
+
+			var got value
+			if !c.Get(ctx, "key", &got) || got != (value{Name: "a", Count: 2}) {
+				t.Errorf("Get = %+v", got)
+			}
+
+			if c.Get(ctx, "missing", &got) {
+				t.Error("Get missing = true")
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStorage - хранилище в памяти процесса, при превышении ограничений вытесняет
+// давно не использованные значения.
+type MemoryStorage struct {
+	maxBytes   int64
+	maxEntries int
+
+	mu       sync.Mutex
+	order    *list.List //в начале - недавно использованные.
+	entries  map[string]*list.Element
+	bytes    int64
+	counters map[string]int64 //не вытесняются, иначе сброс счетчика вернул бы устаревшие значения.
+}
+
+type memoryEntry struct {
+	key     string
+	value   []byte
+	expires time.Time //нулевое время - бессрочно.
+}
+
+func NewMemory(maxBytes int64, maxEntries int) *MemoryStorage {
+	return &MemoryStorage{
+		maxBytes:   maxBytes,
+		maxEntries: maxEntries,
+		order:      list.New(),
+		entries:    make(map[string]*list.Element),
+		counters:   make(map[string]int64),
+	}
+}
+
+func (s *MemoryStorage) Get(_ context.Context, key string) ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if counter, ok := s.counters[key]; ok {
+		return []byte(strconv.FormatInt(counter, 10)), true, nil
+	}
+
+	el, ok := s.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+
+	e := el.Value.(*memoryEntry)
+
+	if !e.expires.IsZero() && time.Now().After(e.expires) {
+		s.remove(el)
+		return nil, false, nil
+	}
+
+	s.order.MoveToFront(el)
+
+	return e.value, true, nil
+}
+
+func (s *MemoryStorage) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if el, ok := s.entries[key]; ok {
+		s.remove(el)
+	}
+
+	e := &memoryEntry{key: key, value: value}
+	if ttl != 0 {
+		e.expires = time.Now().Add(ttl)
+	}
+
+	//значение больше всего кэша не сохраняется, чтобы не вытеснять остальные.
+	if s.maxBytes != 0 && e.size() > s.maxBytes {
+		return nil
+	}
+
+	s.entries[key] = s.order.PushFront(e)
+	s.bytes += e.size()
+
+	for (s.maxBytes != 0 && s.bytes > s.maxBytes) || (s.maxEntries != 0 && s.order.Len() > s.maxEntries) {
+		s.remove(s.order.Back())
+	}
+
+	return nil
+}
+
+func (s *MemoryStorage) Incr(_ context.Context, key string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.counters[key]++
+
+	return s.counters[key], nil
+}
+
+func (s *MemoryStorage) Purge(_ context.Context, prefix string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := 0
+
+	for key, el := range s.entries {
+		if strings.HasPrefix(key, prefix) {
+			s.remove(el)
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+func (s *MemoryStorage) Close() error {
+	return nil
+}
+
+func (s *MemoryStorage) remove(el *list.Element) {
+	e := s.order.Remove(el).(*memoryEntry)
+	delete(s.entries, e.key)
+	s.bytes -= e.size()
+}
+
+func (e *memoryEntry) size() int64 {
+	return int64(len(e.key) + len(e.value))
+}
@@ -0,0 +1,89 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"github.com/redis/go-redis/v9"
+	"strings"
+	"time"
+)
+
+// RedisStorage - хранилище на сервере с протоколом Redis, общее для всех экземпляров приложения.
+// Счетчики хранятся без срока, но при политике вытеснения allkeys-* сервер может их удалить.
+type RedisStorage struct {
+	client *redis.Client
+	prefix string
+}
+
+func NewRedis(cfg RedisConfig) (*RedisStorage, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr:     cfg.Addr,
+		Username: cfg.Username,
+		Password: cfg.Password,
+		DB:       cfg.DB,
+	})
+
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		_ = client.Close()
+		return nil, err
+	}
+
+	return &RedisStorage{client: client, prefix: cfg.Prefix}, nil
+}
+
+func (s *RedisStorage) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	value, err := s.client.Get(ctx, s.prefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	return value, true, nil
+}
+
+func (s *RedisStorage) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return s.client.Set(ctx, s.prefix+key, value, ttl).Err()
+}
+
+func (s *RedisStorage) Incr(ctx context.Context, key string) (int64, error) {
+	return s.client.Incr(ctx, s.prefix+key).Result()
+}
+
+func (s *RedisStorage) Purge(ctx context.Context, prefix string) (int, error) {
+	var (
+		cursor uint64
+		count  int
+	)
+
+	for {
+		keys, next, err := s.client.Scan(ctx, cursor, escapePattern(s.prefix+prefix)+"*", 1000).Result()
+		if err != nil {
+			return count, err
+		}
+
+		if len(keys) != 0 {
+			var deleted int64
+
+			if deleted, err = s.client.Unlink(ctx, keys...).Result(); err != nil {
+				return count, err
+			}
+
+			count += int(deleted)
+		}
+
+		if cursor = next; cursor == 0 {
+			return count, nil
+		}
+	}
+}
+
+func (s *RedisStorage) Close() error {
+	return s.client.Close()
+}
+
+// escapePattern экранирует специальные символы шаблона SCAN MATCH.
+func escapePattern(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`).Replace(s)
+}
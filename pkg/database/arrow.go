@@ -0,0 +1,229 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/ipc"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+	"io"
+	"strconv"
+	"time"
+)
+
+// ArrowStream - тип содержимого Arrow IPC stream.
+const ArrowStream = "application/vnd.apache.arrow.stream"
+
+// DefaultArrowBatch - количество строк в одном пакете Arrow.
+const DefaultArrowBatch = 4096
+
+// arrowDbType - ключ метаданных поля Arrow с типом драйвера.
+const arrowDbType = "dbType"
+
+// timestampLayout - формат значений TIMESTAMP после normalize.
+const timestampLayout = "2006-01-02T15:04:05.999999999"
+
+// RowReader - строки select, которые читаются по мере получения от сервера, например Rows.
+type RowReader interface {
+	Next() bool
+	Values() ([]any, error) //значения в порядке столбцов, приведенные к значениям JSON.
+	Err() error
+}
+
+// WriteArrow записывает строки select в формате Arrow IPC stream пакетами по batchSize строк,
+// не дожидаясь остальных строк. Если w умеет сбрасывать буфер, он сбрасывается после каждого пакета.
+// Значения TIMESTAMPTZ получают часовой пояс timeZone, по умолчанию UTC.
+func WriteArrow(w io.Writer, columns []ResultColumn, rows RowReader, timeZone string, batchSize int) error {
+	if len(timeZone) == 0 {
+		timeZone = "UTC"
+	}
+
+	fields := make([]arrow.Field, len(columns))
+	for i, c := range columns {
+		fields[i] = arrow.Field{
+			Name:     c.Name,
+			Type:     arrowType(c, timeZone),
+			Nullable: true,
+			Metadata: arrow.NewMetadata([]string{arrowDbType}, []string{c.DbType}),
+		}
+	}
+
+	schema := arrow.NewSchema(fields, nil)
+
+	b := array.NewRecordBuilder(memory.DefaultAllocator, schema)
+	defer b.Release()
+
+	iw := ipc.NewWriter(w, ipc.WithSchema(schema), ipc.WithAllocator(memory.DefaultAllocator))
+
+	flusher, _ := w.(interface{ Flush() error })
+
+	batch := 0
+
+	write := func() error {
+		record := b.NewRecord()
+		err := iw.Write(record)
+		record.Release()
+
+		if err != nil {
+			return err
+		}
+
+		batch = 0
+
+		if flusher != nil {
+			return flusher.Flush()
+		}
+		return nil
+	}
+
+	for rows.Next() {
+		values, err := rows.Values()
+		if err != nil {
+			return err
+		}
+
+		for i, c := range columns {
+			if err = appendArrow(b.Field(i), values[i]); err != nil {
+				return fmt.Errorf("неверное значение столбца %s: %s", c.Name, err.Error())
+			}
+		}
+
+		if batch++; batch == batchSize {
+			if err = write(); err != nil {
+				return err
+			}
+		}
+	}
+
+	//ошибка или истечение времени запроса посреди строк.
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("не удалось исполнить запрос: %s", err.Error())
+	}
+
+	if batch != 0 {
+		if err := write(); err != nil {
+			return err
+		}
+	}
+
+	//Close записывает схему, если пакетов не было, и признак конца потока.
+	return iw.Close()
+}
+
+// arrowType возвращает тип Arrow по типу драйвера.
+func arrowType(c ResultColumn, timeZone string) arrow.DataType {
+	switch c.DbType {
+	case "INT2", "INT4", "INT8", "OID":
+		return arrow.PrimitiveTypes.Int64
+	case "FLOAT4", "FLOAT8":
+		return arrow.PrimitiveTypes.Float64
+	case "BOOL":
+		return arrow.FixedWidthTypes.Boolean
+	case "DATE":
+		return arrow.FixedWidthTypes.Date32
+	case "TIMESTAMP":
+		return &arrow.TimestampType{Unit: arrow.Microsecond}
+	case "TIMESTAMPTZ":
+		return &arrow.TimestampType{Unit: arrow.Microsecond, TimeZone: timeZone}
+	default:
+		//NUMERIC передается текстом, чтобы не потерять точность.
+		return arrow.BinaryTypes.String
+	}
+}
+
+// appendArrow добавляет значение после normalize.
+func appendArrow(b array.Builder, value any) error {
+	if value == nil {
+		b.AppendNull()
+		return nil
+	}
+
+	switch b := b.(type) {
+	case *array.Int64Builder:
+		var (
+			n   int64
+			err error
+		)
+
+		switch v := value.(type) {
+		case int64:
+			n = v
+		case json.Number:
+			n, err = v.Int64()
+		case string:
+			//большие целые передаются строками.
+			n, err = strconv.ParseInt(v, 10, 64)
+		default:
+			err = fmt.Errorf("ожидается целое число, получено %T", value)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		b.Append(n)
+
+	case *array.Float64Builder:
+		//NaN и бесконечности передаются строками, toNumber их разбирает.
+		f, err := toNumber(value)
+		if err != nil {
+			return err
+		}
+
+		b.Append(f)
+
+	case *array.BooleanBuilder:
+		v, ok := value.(bool)
+		if !ok {
+			return fmt.Errorf("ожидается логическое значение, получено %T", value)
+		}
+
+		b.Append(v)
+
+	case *array.Date32Builder:
+		t, err := parseArrowTime(time.DateOnly, value)
+		if err != nil {
+			return err
+		}
+
+		b.Append(arrow.Date32FromTime(t))
+
+	case *array.TimestampBuilder:
+		layout := time.RFC3339Nano
+		if b.Type().(*arrow.TimestampType).TimeZone == "" {
+			layout = timestampLayout
+		}
+
+		t, err := parseArrowTime(layout, value)
+		if err != nil {
+			return err
+		}
+
+		b.Append(arrow.Timestamp(t.UnixMicro()))
+
+	case *array.StringBuilder:
+		switch v := value.(type) {
+		case string:
+			b.Append(v)
+		case json.Number:
+			b.Append(v.String())
+		default:
+			b.Append(fmt.Sprint(v))
+		}
+
+	default:
+		return fmt.Errorf("неподдерживаемый тип Arrow %s", b.Type())
+	}
+
+	return nil
+}
+
+func parseArrowTime(layout string, value any) (time.Time, error) {
+	s, ok := value.(string)
+	if !ok {
+		return time.Time{}, fmt.Errorf("ожидается строка в формате %s, получено %T", layout, value)
+	}
+
+	return time.Parse(layout, s)
+}
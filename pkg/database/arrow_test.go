@@ -0,0 +1,215 @@
+package database
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/ipc"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+)
+
+// sliceRows - строки select из памяти, которые отдаются по одной.
+type sliceRows struct {
+	rows [][]any
+	next int
+	err  error //ошибка после всех строк, например истечение времени запроса.
+}
+
+func (r *sliceRows) Next() bool {
+	if r.next == len(r.rows) {
+		return false
+	}
+	r.next++
+	return true
+}
+
+func (r *sliceRows) Values() ([]any, error) {
+	return r.rows[r.next-1], nil
+}
+
+func (r *sliceRows) Err() error {
+	return r.err
+}
+
+var arrowColumns = []ResultColumn{
+	{Name: "id", DbType: "INT8"},
+	{Name: "price", DbType: "NUMERIC"},
+	{Name: "ratio", DbType: "FLOAT8"},
+	{Name: "active", DbType: "BOOL"},
+	{Name: "day", DbType: "DATE"},
+	{Name: "local", DbType: "TIMESTAMP"},
+	{Name: "at", DbType: "TIMESTAMPTZ"},
+	{Name: "name", DbType: "TEXT"},
+}
+
+func arrowRows() *sliceRows {
+	return &sliceRows{rows: [][]any{
+		{
+			int64(1),
+			json.Number("12345678901234567890.123456789"),
+			0.5,
+			true,
+			"2024-02-29",
+			"2024-02-29T10:30:00.123456",
+			"2024-02-29T13:30:00.5+03:00",
+			"Иван",
+		},
+		{"9007199254740993", "NaN", "NaN", nil, nil, nil, nil, nil},
+		{json.Number("3"), nil, nil, nil, nil, nil, nil, nil},
+	}}
+}
+
+// flushWriter считает, сколько раз сбрасывался буфер, и сколько байт было записано к каждому сбросу.
+type flushWriter struct {
+	bytes.Buffer
+	flushed []int
+}
+
+func (w *flushWriter) Flush() error {
+	w.flushed = append(w.flushed, w.Len())
+	return nil
+}
+
+func readArrow(t *testing.T, data []byte) (*arrow.Schema, []arrow.Record) {
+	t.Helper()
+
+	r, err := ipc.NewReader(bytes.NewReader(data), ipc.WithAllocator(memory.DefaultAllocator))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Release()
+
+	var records []arrow.Record
+	for r.Next() {
+		record := r.Record()
+		record.Retain()
+		records = append(records, record)
+	}
+
+	if err = r.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	return r.Schema(), records
+}
+
+func TestWriteArrow(t *testing.T) {
+	var buf flushWriter
+
+	if err := WriteArrow(&buf, arrowColumns, arrowRows(), "Europe/Moscow", 2); err != nil {
+		t.Fatal(err)
+	}
+
+	schema, records := readArrow(t, buf.Bytes())
+
+	//каждый пакет сбрасывается клиенту сразу после записи.
+	if len(buf.flushed) != 2 || buf.flushed[0] >= buf.flushed[1] {
+		t.Errorf("flushes = %v, want one per batch", buf.flushed)
+	}
+
+	wantTypes := []arrow.DataType{
+		arrow.PrimitiveTypes.Int64,
+		arrow.BinaryTypes.String,
+		arrow.PrimitiveTypes.Float64,
+		arrow.FixedWidthTypes.Boolean,
+		arrow.FixedWidthTypes.Date32,
+		&arrow.TimestampType{Unit: arrow.Microsecond},
+		&arrow.TimestampType{Unit: arrow.Microsecond, TimeZone: "Europe/Moscow"},
+		arrow.BinaryTypes.String,
+	}
+
+	for i, f := range schema.Fields() {
+		if !arrow.TypeEqual(f.Type, wantTypes[i]) {
+			t.Errorf("field %s type = %s, want %s", f.Name, f.Type, wantTypes[i])
+		}
+	}
+
+	if got, _ := schema.Field(1).Metadata.GetValue(arrowDbType); got != "NUMERIC" {
+		t.Errorf("price dbType = %q", got)
+	}
+
+	if len(records) != 2 || records[0].NumRows() != 2 || records[1].NumRows() != 1 {
+		t.Fatalf("batches = %d, want 2 batches of 2 and 1 rows", len(records))
+	}
+
+	first := records[0]
+
+	ids := first.Column(0).(*array.Int64)
+	if ids.Value(0) != 1 || ids.Value(1) != 9007199254740993 {
+		t.Errorf("id = %d, %d", ids.Value(0), ids.Value(1))
+	}
+
+	//NUMERIC сохраняет все цифры.
+	if got := first.Column(1).(*array.String).Value(0); got != "12345678901234567890.123456789" {
+		t.Errorf("price = %s", got)
+	}
+
+	if got := first.Column(4).(*array.Date32).Value(0).ToTime(); !got.Equal(time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("day = %s", got)
+	}
+
+	local := first.Column(5).(*array.Timestamp).Value(0)
+	if want := time.Date(2024, 2, 29, 10, 30, 0, 123456000, time.UTC).UnixMicro(); int64(local) != want {
+		t.Errorf("local = %d, want %d", local, want)
+	}
+
+	at := first.Column(6).(*array.Timestamp).Value(0)
+	if want := time.Date(2024, 2, 29, 10, 30, 0, 500000000, time.UTC).UnixMicro(); int64(at) != want {
+		t.Errorf("at = %d, want %d", at, want)
+	}
+
+	for i := 3; i < 8; i++ {
+		if !first.Column(i).IsNull(1) {
+			t.Errorf("column %s is not null in row 2", schema.Field(i).Name)
+		}
+	}
+
+	if got := records[1].Column(0).(*array.Int64).Value(0); got != 3 {
+		t.Errorf("id in batch 2 = %d", got)
+	}
+}
+
+func TestWriteArrowEmpty(t *testing.T) {
+	var buf bytes.Buffer
+
+	if err := WriteArrow(&buf, []ResultColumn{{Name: "id", DbType: "INT4"}}, &sliceRows{}, "", DefaultArrowBatch); err != nil {
+		t.Fatal(err)
+	}
+
+	schema, records := readArrow(t, buf.Bytes())
+
+	if len(schema.Fields()) != 1 || len(records) != 0 {
+		t.Errorf("fields = %d, batches = %d, want schema only", len(schema.Fields()), len(records))
+	}
+}
+
+func TestWriteArrowInvalid(t *testing.T) {
+	rows := &sliceRows{rows: [][]any{{"да"}}}
+
+	if err := WriteArrow(&bytes.Buffer{}, []ResultColumn{{Name: "active", DbType: "BOOL"}}, rows, "", DefaultArrowBatch); err == nil {
+		t.Error("err = nil, want invalid value error")
+	}
+}
+
+// ошибка посреди строк прерывает поток, уже отправленные пакеты остаются.
+func TestWriteArrowRowsError(t *testing.T) {
+	var buf flushWriter
+
+	rows := arrowRows()
+	rows.err = errors.New("canceling statement due to statement timeout")
+
+	err := WriteArrow(&buf, arrowColumns, rows, "", 2)
+	if err == nil || !strings.Contains(err.Error(), "statement timeout") {
+		t.Fatalf("err = %v, want rows error", err)
+	}
+
+	if len(buf.flushed) != 1 {
+		t.Errorf("flushes = %v, want the first batch only", buf.flushed)
+	}
+}
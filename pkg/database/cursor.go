@@ -0,0 +1,77 @@
+package database
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	sq "github.com/Masterminds/squirrel"
+)
+
+// cursor - позиция последней строки страницы при постраничном чтении по ключу.
+type cursor struct {
+	Keys []any `json:"k"`  //значения столбцов сортировки.
+	Id   any   `json:"id"` //значение первичного ключа.
+}
+
+func (c cursor) encode() (string, error) {
+	for i, key := range c.Keys {
+		if b, ok := key.([]byte); ok {
+			c.Keys[i] = string(b)
+		}
+	}
+
+	if b, ok := c.Id.([]byte); ok {
+		c.Id = string(b)
+	}
+
+	data, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeCursor(s string) (cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor{}, fmt.Errorf("неверный курсор")
+	}
+
+	var c cursor
+
+	//числа остаются строками, чтобы не потерять точность bigint и numeric.
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+
+	if err = d.Decode(&c); err != nil {
+		return cursor{}, fmt.Errorf("неверный курсор")
+	}
+
+	return c, nil
+}
+
+// keysetWhere - условие отбора строк, которые следуют за курсором
+// при сортировке по exprs. Значения NULL в столбцах сортировки не поддерживаются.
+func keysetWhere(exprs []string, desc []bool, values []any) sq.Or {
+	where := make(sq.Or, 0, len(exprs))
+
+	for i := range exprs {
+		and := make(sq.And, 0, i+1)
+
+		for j := 0; j < i; j++ {
+			and = append(and, sq.Eq{exprs[j]: values[j]})
+		}
+
+		if desc[i] {
+			and = append(and, sq.Lt{exprs[i]: values[i]})
+		} else {
+			and = append(and, sq.Gt{exprs[i]: values[i]})
+		}
+
+		where = append(where, and)
+	}
+
+	return where
+}
@@ -3,11 +3,19 @@ package database
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
+	"datapointbackend/pkg/secret"
+	"encoding/json"
 	"fmt"
 	sq "github.com/Masterminds/squirrel"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
+	"io"
+	"net"
+	"net/url"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 )
 
 const (
@@ -19,8 +27,89 @@ type Config struct {
 	Port         int    `json:"port" yaml:"port"`
 	Username     string `json:"username" yaml:"username"`
 	Password     string `json:"password" yaml:"password"`
+	PasswordRef  string `json:"passwordRef" yaml:"password_ref"` //ссылка на пароль вместо него, например env:DATAPOINT_SOURCE_PG_PASS.
 	DatabaseName string `json:"databaseName" yaml:"database_name"`
 	Driver       string `json:"driver" yaml:"driver"`
+
+	Limits `yaml:",inline"`
+
+	//время ожидания запросов в миллисекундах, 0 - без ограничений.
+	DefaultTimeout uint `json:"defaultTimeout" yaml:"default_timeout"` //если в запросе не указано иное.
+	MaxTimeout     uint `json:"maxTimeout" yaml:"max_timeout"`         //верхняя граница для любого запроса.
+
+	CacheTTL uint `json:"cacheTtl" yaml:"cache_ttl"` //время хранения результатов select в секундах, 0 - не кэшируются.
+
+	Pool `yaml:",inline"`
+	TLS  `yaml:",inline"`
+
+	//через что подключаться к серверу, например через SSH-туннель, по умолчанию напрямую.
+	//Если Dialer реализует io.Closer, он закрывается вместе с Database.
+	Dialer Dialer `json:"-" yaml:"-"`
+}
+
+type Dialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// Pool - настройки пула подключений, 0 - значение по умолчанию database/sql.
+type Pool struct {
+	MaxOpenConns    int  `json:"maxOpenConns" yaml:"max_open_conns"`
+	MaxIdleConns    int  `json:"maxIdleConns" yaml:"max_idle_conns"`
+	ConnMaxLifetime uint `json:"connMaxLifetime" yaml:"conn_max_lifetime"`  //в секундах.
+	ConnMaxIdleTime uint `json:"connMaxIdleTime" yaml:"conn_max_idle_time"` //в секундах.
+}
+
+func (p Pool) apply(conn *sql.DB) {
+	if p.MaxOpenConns != 0 {
+		conn.SetMaxOpenConns(p.MaxOpenConns)
+	}
+
+	if p.MaxIdleConns != 0 {
+		conn.SetMaxIdleConns(p.MaxIdleConns)
+	}
+
+	if p.ConnMaxLifetime != 0 {
+		conn.SetConnMaxLifetime(time.Duration(p.ConnMaxLifetime) * time.Second)
+	}
+
+	if p.ConnMaxIdleTime != 0 {
+		conn.SetConnMaxIdleTime(time.Duration(p.ConnMaxIdleTime) * time.Second)
+	}
+}
+
+// Limits - ограничения результата select, 0 - без ограничений.
+type Limits struct {
+	MaxRows  uint64 `json:"maxRows" yaml:"max_rows"`
+	MaxBytes uint64 `json:"maxBytes" yaml:"max_bytes"` //приблизительный объем значений строк.
+}
+
+// Merge возвращает более строгие из двух ограничений.
+func (l Limits) Merge(other Limits) Limits {
+	return Limits{
+		MaxRows:  minLimit(l.MaxRows, other.MaxRows),
+		MaxBytes: minLimit(l.MaxBytes, other.MaxBytes),
+	}
+}
+
+func minLimit(a, b uint64) uint64 {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+// Timeout возвращает время ожидания запроса в миллисекундах с учетом ограничений источника.
+func (cfg *Config) Timeout(requested uint) uint {
+	timeout := requested
+	if timeout == 0 {
+		timeout = cfg.DefaultTimeout
+	}
+
+	if cfg.MaxTimeout != 0 && (timeout == 0 || timeout > cfg.MaxTimeout) {
+		timeout = cfg.MaxTimeout
+	}
+
+	return timeout
 }
 
 func (cfg *Config) Build() (
@@ -31,35 +120,148 @@ func (cfg *Config) Build() (
 ) {
 	switch cfg.Driver {
 	case PostgreSQL:
-		return "postgres", fmt.Sprintf(
-			"postgresql://%s:%s@%s:%d/%s?sslmode=disable",
-			cfg.Username, cfg.Password, cfg.Host, cfg.Port, cfg.DatabaseName,
-		), sq.Dollar, nil
+		dsn := url.URL{
+			Scheme: "postgresql",
+			User:   url.UserPassword(cfg.Username, cfg.Password),
+			Host:   net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port)),
+			Path:   cfg.DatabaseName,
+			//TLS, если он нужен, устанавливает Connector.
+			RawQuery: url.Values{"sslmode": {SSLDisable}}.Encode(),
+		}
+
+		return "postgres", dsn.String(), sq.Dollar, nil
 
 	default:
 		return "", "", nil, fmt.Errorf("неизвестный драйвер %s", cfg.Driver)
 	}
 }
 
+// Connector возвращает подключение с учетом настроек, которых нет в строке подключения.
+func (cfg *Config) Connector() (driver.Connector, sq.PlaceholderFormat, error) {
+	_, dataSourceName, placeholder, err := cfg.Build()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	//ссылка приходит от пользователя, поэтому проверяется до сохранения источника.
+	if len(cfg.PasswordRef) != 0 {
+		if err = secret.Validate(cfg.PasswordRef); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	switch cfg.Driver {
+	case PostgreSQL:
+		var c *pq.Connector
+
+		if c, err = pq.NewConnector(dataSourceName); err != nil {
+			return nil, nil, err
+		}
+
+		if cfg.TLS.enabled() || cfg.Dialer != nil {
+			d := pqDialer{dialer: cfg.Dialer}
+
+			if d.dialer == nil {
+				d.dialer = &net.Dialer{}
+			}
+
+			if cfg.TLS.enabled() {
+				if d.tls, err = cfg.TLS.config(cfg.Host); err != nil {
+					return nil, nil, err
+				}
+			}
+
+			c.Dialer(&d)
+		}
+
+		if len(cfg.PasswordRef) != 0 {
+			return &secretConnector{cfg: *cfg, driver: c.Driver()}, placeholder, nil
+		}
+
+		return c, placeholder, nil
+
+	default:
+		return nil, nil, fmt.Errorf("неизвестный драйвер %s", cfg.Driver)
+	}
+}
+
+// secretConnector получает пароль по ссылке при каждом новом подключении пула,
+// поэтому замененный пароль используется без перезапуска.
+type secretConnector struct {
+	cfg    Config
+	driver driver.Driver
+}
+
+func (c *secretConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	cfg := c.cfg
+
+	password, err := secret.Resolve(ctx, cfg.PasswordRef)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.Password, cfg.PasswordRef = password, ""
+
+	var connector driver.Connector
+
+	if connector, _, err = cfg.Connector(); err != nil {
+		return nil, err
+	}
+
+	return connector.Connect(ctx)
+}
+
+func (c *secretConnector) Driver() driver.Driver {
+	return c.driver
+}
+
 type Database struct {
 	Conn    *sql.DB
 	Builder sq.StatementBuilderType
 	Config  Config
+	Schema  Schema //источник сведений о таблицах при разборе запросов, по умолчанию GetTable.
+
+	//счетчики исполненных запросов для Stats.
+	queries atomic.Uint64
+	failed  atomic.Uint64
+	elapsed atomic.Int64 //суммарное время исполнения в наносекундах.
+}
+
+// Schema возвращает сведения о таблице, например из кэша.
+type Schema interface {
+	GetTable(ctx context.Context, name string) (*Table, error)
+}
+
+func (db *Database) table(ctx context.Context, name string) (*Table, error) {
+	if db.Schema != nil {
+		return db.Schema.GetTable(ctx, name)
+	}
+	return db.GetTable(ctx, name)
+}
+
+// Close закрывает пул подключений и Dialer источника.
+func (db *Database) Close() error {
+	err := db.Conn.Close()
+
+	if closer, ok := db.Config.Dialer.(io.Closer); ok {
+		_ = closer.Close()
+	}
+
+	return err
 }
 
 func New(cfg Config) (*Database, error) {
-	driverName, dataSourceName, placeholder, err := cfg.Build()
+	connector, placeholder, err := cfg.Connector()
 	if err != nil {
 		return nil, err
 	}
 
-	var conn *sql.DB
+	conn := sql.OpenDB(connector)
 
-	if conn, err = sql.Open(driverName, dataSourceName); err != nil {
-		return nil, err
-	}
+	cfg.Pool.apply(conn)
 
 	if err = conn.Ping(); err != nil {
+		_ = conn.Close()
 		return nil, err
 	}
 
@@ -144,6 +346,7 @@ const (
 type Column struct {
 	Name     string `json:"name"`
 	Type     string `json:"type"`
+	DataType string `json:"dataType"` //тип столбца в базе данных.
 	Required bool   `json:"required"`
 	IsPKey   bool   `json:"isPKey"`
 }
@@ -163,7 +366,25 @@ func (t *Table) GetPKey() (Column, bool) {
 }
 
 func (db *Database) GetTables(ctx context.Context) ([]*Table, error) {
-	rows, err := db.Builder.
+	return db.introspect(ctx, nil)
+}
+
+// GetTable читает сведения только об одной таблице.
+func (db *Database) GetTable(ctx context.Context, name string) (*Table, error) {
+	tables, err := db.introspect(ctx, sq.Eq{"c.table_name": name})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tables) == 0 {
+		return nil, fmt.Errorf("таблицы с именем %s не существует", name)
+	}
+
+	return tables[0], nil
+}
+
+func (db *Database) introspect(ctx context.Context, where sq.Sqlizer) ([]*Table, error) {
+	b := db.Builder.
 		Select(
 			"c.table_name",
 			"c.column_name",
@@ -175,8 +396,13 @@ func (db *Database) GetTables(ctx context.Context) ([]*Table, error) {
 		LeftJoin("information_schema.constraint_column_usage ccu USING (table_name, column_name)").
 		LeftJoin("information_schema.table_constraints tc USING (constraint_name)").
 		Where("c.table_schema = 'public' AND (tc.constraint_type = 'PRIMARY KEY' OR tc.constraint_type IS NULL)").
-		OrderBy("c.table_name", "c.column_name").
-		QueryContext(ctx)
+		OrderBy("c.table_name", "c.column_name")
+
+	if where != nil {
+		b = b.Where(where)
+	}
+
+	rows, err := b.QueryContext(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -203,27 +429,12 @@ func (db *Database) GetTables(ctx context.Context) ([]*Table, error) {
 			tableAcc[tName] = newT
 		}
 
-		c.Type = cType.ToJSON()
+		c.Type, c.DataType = cType.ToJSON(), string(cType)
 
 		tableAcc[tName].Columns = append(tableAcc[tName].Columns, c)
 	}
 
-	return tables, nil
-}
-
-func (db *Database) GetTable(ctx context.Context, name string) (*Table, error) {
-	tables, err := db.GetTables(ctx)
-	if err != nil {
-		return nil, err
-	}
-
-	for _, table := range tables {
-		if table.Name == name {
-			return table, nil
-		}
-	}
-
-	return nil, fmt.Errorf("таблицы с именем %s не существует", name)
+	return tables, rows.Err()
 }
 
 const (
@@ -239,12 +450,33 @@ type Query struct {
 	Columns []*QColumn `json:"columns"`
 	Where   []*QColumn `json:"where"`
 
+	Parameters []Parameter `json:"parameters"` //параметры, на которые ссылаются условия where и limit.
+
+	Timeout uint `json:"timeout"` //время ожидания в миллисекундах, 0 - по умолчанию для источника.
+
 	//используется только в select.
-	OrderBy []*QColumn `json:"orderBy"`
-	Limit   uint64     `json:"limit"`
-	Offset  uint64     `json:"offset"`
+	CacheTTL *uint `json:"cacheTtl"` //время хранения результата в секундах, null - по умолчанию для источника, 0 - не кэшируется.
+
+	//используется только в update и delete.
+	AllowFullTable bool `json:"allowFullTable"` //разрешает запись без условий отбора.
+
+	//используется только в select.
+	OrderBy    []*QColumn `json:"orderBy"`
+	Limit      uint64     `json:"limit"`
+	LimitParam string     `json:"limitParam"` //имя параметра, значение которого заменяет limit.
+	Offset     uint64     `json:"offset"`
+	Cursor     string     `json:"cursor"`   //nextCursor предыдущей страницы, если указан, offset не учитывается.
+	Total      string     `json:"total"`    //способ подсчета общего количества строк, по умолчанию exact.
+	TimeZone   string     `json:"timeZone"` //часовой пояс IANA для значений с часовым поясом, по умолчанию UTC.
 }
 
+// способы подсчета общего количества строк select.
+const (
+	TotalExact     = "exact"     //отдельный запрос COUNT(*).
+	TotalEstimated = "estimated" //оценка планировщика.
+	TotalNone      = "none"      //не подсчитывается.
+)
+
 type QTableKey struct {
 	Name      string `json:"name"`      //имя таблицы.
 	Increment uint8  `json:"increment"` //приращение имени для создания уникальных псевдонимов.
@@ -273,6 +505,15 @@ func (t *QTable) Full() string {
 	return fmt.Sprintf(`%s "%s"`, t.Partial(), t.String())
 }
 
+// Names возвращает имена таблицы и всех объединенных с ней таблиц.
+func (t *QTable) Names() []string {
+	names := []string{t.Name}
+	for _, next := range t.Next {
+		names = append(names, next.Names()...)
+	}
+	return names
+}
+
 func (t *QTable) Join(b sq.SelectBuilder) (sq.SelectBuilder, error) {
 	for _, nextQt := range t.Next {
 		join := []string{nextQt.Full(), "ON"}
@@ -378,6 +619,22 @@ type QResponse struct {
 	Data   any    `json:"data"`
 	Err    string `json:"err"`
 	RawSql string `json:"rawSql"`
+	Cached bool   `json:"cached"` //результат взят из кэша.
+
+	Mutation *Mutation `json:"-"` //заполняется только в insert, update и delete.
+}
+
+// Mutation - сведения об изменении данных для журнала аудита.
+type Mutation struct {
+	Type         string
+	Table        string
+	RawSql       string
+	Args         []any
+	RowsAffected int64
+	//снимки строк до и после изменения по значению первичного ключа,
+	//отсутствуют, если у таблицы нет первичного ключа или строк больше snapshotLimit.
+	Before map[string]map[string]any
+	After  map[string]map[string]any
 }
 
 func (r QResponse) Errorf(format string, a ...any) QResponse {
@@ -393,87 +650,446 @@ func (r QResponse) errParse(err error) QResponse {
 	return r.Errorf("не удалось разобрать запрос: %s", err.Error())
 }
 
+// Trace - обработчики событий исполнения запроса.
+type Trace struct {
+	//вызывается с изменением insert, update или delete до фиксации транзакции: ошибка отменяет изменение,
+	//а возвращенная функция вызывается после попытки фиксации с ее результатом.
+	Mutated func(m Mutation) (func(committed bool), error)
+}
+
+type traceKey struct{}
+
+// WithTrace добавляет в контекст обработчики событий исполнения запроса.
+func WithTrace(ctx context.Context, trace *Trace) context.Context {
+	return context.WithValue(ctx, traceKey{}, trace)
+}
+
+func traceFrom(ctx context.Context) *Trace {
+	trace, _ := ctx.Value(traceKey{}).(*Trace)
+	return trace
+}
+
+// Stats - состояние пула подключений и задержка запросов источника.
+type Stats struct {
+	MaxOpenConnections int     `json:"maxOpenConnections"`
+	OpenConnections    int     `json:"openConnections"`
+	InUse              int     `json:"inUse"`
+	Idle               int     `json:"idle"`
+	WaitCount          int64   `json:"waitCount"`
+	WaitDuration       float64 `json:"waitDuration"` //суммарное ожидание подключения в миллисекундах.
+	MaxIdleClosed      int64   `json:"maxIdleClosed"`
+	MaxIdleTimeClosed  int64   `json:"maxIdleTimeClosed"`
+	MaxLifetimeClosed  int64   `json:"maxLifetimeClosed"`
+	Queries            uint64  `json:"queries"`
+	Failed             uint64  `json:"failed"`
+	AvgLatency         float64 `json:"avgLatency"` //среднее время исполнения запроса в миллисекундах.
+}
+
+func (db *Database) Stats() Stats {
+	pool := db.Conn.Stats()
+
+	stats := Stats{
+		MaxOpenConnections: pool.MaxOpenConnections,
+		OpenConnections:    pool.OpenConnections,
+		InUse:              pool.InUse,
+		Idle:               pool.Idle,
+		WaitCount:          pool.WaitCount,
+		WaitDuration:       milliseconds(pool.WaitDuration),
+		MaxIdleClosed:      pool.MaxIdleClosed,
+		MaxIdleTimeClosed:  pool.MaxIdleTimeClosed,
+		MaxLifetimeClosed:  pool.MaxLifetimeClosed,
+		Queries:            db.queries.Load(),
+		Failed:             db.failed.Load(),
+	}
+
+	if stats.Queries != 0 {
+		stats.AvgLatency = milliseconds(time.Duration(db.elapsed.Load())) / float64(stats.Queries)
+	}
+
+	return stats
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
 func (db *Database) Execute(ctx context.Context, query Query) QResponse {
+	started := time.Now()
+
+	response := db.execute(ctx, query)
+
+	db.queries.Add(1)
+	db.elapsed.Add(int64(time.Since(started)))
+
+	if len(response.Err) != 0 {
+		db.failed.Add(1)
+	}
+
+	return response
+}
+
+func (db *Database) execute(ctx context.Context, query Query) QResponse {
+	timeout := db.Config.Timeout(query.Timeout)
+
+	ctx, cancel := withTimeout(ctx, timeout)
+	defer cancel()
+
+	//select без ограничения на стороне сервера исполняется без транзакции,
+	//чтобы не тратить на нее лишние обращения к источнику.
+	if query.Type == Select && !db.serverTimeout(timeout) {
+		return db.executeSelect(ctx, db.Conn, query)
+	}
+
+	tx, err := db.begin(ctx, timeout)
+	if err != nil {
+		return QResponse{}.errExecute(err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var response QResponse
+
 	switch query.Type {
 	case Select:
-		return db.executeSelect(ctx, query)
+		response = db.executeSelect(ctx, tx, query)
 	case Insert:
-		return db.executeInsert(ctx, query)
+		response = db.executeInsert(ctx, tx, query)
 	case Update:
-		return db.executeUpdate(ctx, query)
+		response = db.executeUpdate(ctx, tx, query)
 	case Delete:
-		return db.executeDelete(ctx, query)
+		response = db.executeDelete(ctx, tx, query)
 
 	default:
 		return QResponse{}.Errorf("неизвестный тип команды %s", query.Type)
 	}
+
+	if len(response.Err) != 0 {
+		return response
+	}
+
+	var committed func(bool)
+
+	if trace := traceFrom(ctx); response.Mutation != nil && trace != nil && trace.Mutated != nil {
+		if committed, err = trace.Mutated(*response.Mutation); err != nil {
+			return QResponse{}.Errorf("изменение отменено: %s", err.Error())
+		}
+	}
+
+	err = tx.Commit()
+
+	if committed != nil {
+		committed(err == nil)
+	}
+
+	if err != nil {
+		return QResponse{}.errExecute(err)
+	}
+
+	return response
+}
+
+// withTimeout ограничивает контекст временем ожидания в миллисекундах, 0 - без ограничений.
+// При отмене контекста драйвер отправляет источнику запрос отмены для своего подключения,
+// поэтому отменить запрос можно только отменой его контекста.
+func withTimeout(ctx context.Context, timeout uint) (context.Context, context.CancelFunc) {
+	if timeout == 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, time.Duration(timeout)*time.Millisecond)
+}
+
+// serverTimeout сообщает, ограничивается ли время исполнения на стороне сервера.
+// Ограничение защищает источник, если соединение с сервисом потеряно и запрос отмены не дошел.
+func (db *Database) serverTimeout(timeout uint) bool {
+	return timeout != 0 && db.Config.Driver == PostgreSQL
+}
+
+// begin открывает транзакцию, в которой исполняется запрос,
+// и ограничивает время исполнения на стороне сервера.
+func (db *Database) begin(ctx context.Context, timeout uint) (*sql.Tx, error) {
+	tx, err := db.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if db.serverTimeout(timeout) {
+		//SET не принимает аргументы, значение - целое число.
+		if _, err = tx.ExecContext(ctx, fmt.Sprintf("SET LOCAL statement_timeout = %d", timeout)); err != nil {
+			_ = tx.Rollback()
+			return nil, err
+		}
+	}
+
+	return tx, nil
 }
 
 const (
 	specialPrefix     = "$"
 	specialRootPrefix = specialPrefix + specialPrefix
 	specialRootId     = specialPrefix + "root_id"
+	specialKeyPrefix  = specialRootPrefix + "key_" //значения столбцов сортировки для курсора.
 )
 
-func (db *Database) executeSelect(ctx context.Context, query Query) QResponse {
-	b, rules, err := db.parseSelect(query)
+// SelectResult - результат select.
+type SelectResult struct {
+	Rules          map[string][]string `json:"rules"`
+	Columns        []ResultColumn      `json:"columns"`
+	Data           []map[string]any    `json:"data"`
+	Total          *uint64             `json:"total"`          //null, если total = none.
+	TotalEstimated bool                `json:"totalEstimated"` //total - оценка планировщика.
+	NextCursor     string              `json:"nextCursor"`     //пустой, если строк больше нет или курсор не поддерживается.
+	Truncated      bool                `json:"truncated"`      //строки отброшены из-за ограничений Limits.
+}
+
+// executeSelect исполняет select в транзакции или прямо в пуле подключений.
+func (db *Database) executeSelect(ctx context.Context, tx sq.StdSqlCtx, query Query) QResponse {
+	loc, err := time.LoadLocation(query.TimeZone)
 	if err != nil {
 		return QResponse{}.errParse(err)
 	}
 
-	b = b.Columns(fmt.Sprintf(`COUNT(*) OVER() "%stotal"`, specialRootPrefix))
+	switch query.Total {
+	case "", TotalExact, TotalEstimated, TotalNone:
+	default:
+		return QResponse{}.Errorf("неизвестный способ подсчета строк %s", query.Total)
+	}
+
+	var (
+		limits   = db.Config.Limits
+		pageSize = query.Limit
+		capped   bool
+	)
+
+	if limits.MaxRows != 0 && (pageSize == 0 || pageSize > limits.MaxRows) {
+		pageSize, capped = limits.MaxRows, true
+	}
+
+	//запрашивается на одну строку больше, чтобы понять, есть ли следующая страница.
+	if pageSize != 0 {
+		query.Limit = pageSize + 1
+	}
+
+	b, rules, err := db.parseSelect(ctx, query)
+	if err != nil {
+		return QResponse{}.errParse(err)
+	}
 
 	var rows *sql.Rows
 
-	if rows, err = b.QueryContext(ctx); err != nil {
+	if rows, err = b.RunWith(tx).QueryContext(ctx); err != nil {
 		return QResponse{}.errExecute(err)
 	}
 	defer func() { _ = rows.Close() }()
 
 	var (
-		data    []map[string]any
-		columns []string
-		total   uint64
+		data      []map[string]any
+		types     []*sql.ColumnType
+		size      uint64
+		hasMore   bool
+		truncated bool
 	)
 
-	if columns, err = rows.Columns(); err != nil {
+	if types, err = rows.ColumnTypes(); err != nil {
 		return QResponse{}.errExecute(err)
 	}
 
-	for rows.Next() {
-		dest, item := make([]any, 0), make(map[string]any)
+	var (
+		columns  = newResultColumns(query, types)
+		big      = make([]bool, len(columns))
+		hidden   = make(map[string]any)
+		keys     = make([]any, 0, len(types))
+		lastKeys []any
+		lastId   any
+		idIndex  = -1
+	)
 
-		for _, c := range columns {
-			if !strings.HasPrefix(c, specialRootPrefix) {
-				item[c] = new(any)
-				dest = append(dest, item[c])
-			}
+	for _, t := range types {
+		if strings.HasPrefix(t.Name(), specialKeyPrefix) {
+			keys = append(keys, nil)
+			hidden[t.Name()] = &keys[len(keys)-1]
 		}
+	}
 
-		if err = rows.Scan(append(dest, &total)...); err != nil {
+	for i, c := range columns {
+		if c.Name == specialRootId {
+			idIndex = i
+		}
+	}
+
+	for rows.Next() {
+		if pageSize != 0 && uint64(len(data)) == pageSize {
+			hasMore = true
+			break
+		}
+
+		var values []any
+
+		if values, err = scanRow(rows, types, hidden, len(columns)); err != nil {
 			return QResponse{}.errExecute(err)
 		}
 
+		item := make(map[string]any, len(columns))
+
+		for i, c := range columns {
+			size += uint64(len(c.Name)) + sizeOf(values[i])
+
+			var isBig bool
+			item[c.Name], isBig = normalize(c.DbType, values[i], loc)
+			big[i] = big[i] || isBig
+		}
+
+		if limits.MaxBytes != 0 && size > limits.MaxBytes {
+			hasMore, truncated = true, true
+			break
+		}
+
 		data = append(data, item)
+
+		if idIndex != -1 {
+			lastKeys, lastId = append(lastKeys[:0], keys...), values[idIndex]
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return QResponse{}.errExecute(err)
+	}
+
+	_ = rows.Close()
+
+	for i := range columns {
+		columns[i].BigNumber = big[i]
+	}
+
+	result := SelectResult{
+		Rules:          rules,
+		Columns:        columns,
+		Data:           data,
+		TotalEstimated: query.Total == TotalEstimated,
+		Truncated:      truncated || (capped && hasMore),
+	}
+
+	if hasMore && idIndex != -1 && len(data) != 0 {
+		if result.NextCursor, err = (cursor{Keys: lastKeys, Id: lastId}).encode(); err != nil {
+			return QResponse{}.errExecute(err)
+		}
+	}
+
+	if query.Total != TotalNone {
+		var total uint64
+
+		if total, err = db.countSelect(ctx, tx, query); err != nil {
+			return QResponse{}.errExecute(err)
+		}
+
+		result.Total = &total
 	}
 
 	rawSql, _ := b.MustSql()
 
 	return QResponse{
-		Data: struct {
-			Rules map[string][]string `json:"rules"`
-			Data  []map[string]any    `json:"data"`
-			Total uint64              `json:"total"`
-		}{
-			Rules: rules,
-			Data:  data,
-			Total: total,
-		},
+		Data:   result,
 		RawSql: rawSql,
 	}
 }
 
-func (db *Database) parseSelect(query Query) (sq.SelectBuilder, map[string][]string, error) {
+// Compile возвращает SQL и аргументы select без исполнения, одинаковые для одинаковых запросов.
+func (db *Database) Compile(ctx context.Context, query Query) (string, []any, error) {
+	if query.Type != Select || query.Table == nil {
+		return "", nil, fmt.Errorf("компилировать можно только select")
+	}
+
+	b, _, err := db.parseSelect(ctx, query)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return b.ToSql()
+}
+
+// countSelect подсчитывает или оценивает общее количество строк select без учета страниц.
+func (db *Database) countSelect(ctx context.Context, tx sq.StdSqlCtx, query Query) (uint64, error) {
+	query.OrderBy, query.Limit, query.Offset, query.Cursor = nil, 0, 0, ""
+
+	b, _, err := db.parseSelect(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	var total uint64
+
+	if query.Total != TotalEstimated {
+		err = db.Builder.
+			Select("COUNT(*)").
+			FromSelect(b, "t").
+			RunWith(tx).
+			QueryRowContext(ctx).
+			Scan(&total)
+		return total, err
+	}
+
+	switch db.Config.Driver {
+	case PostgreSQL:
+		var (
+			plan []struct {
+				Plan struct {
+					Rows float64 `json:"Plan Rows"`
+				} `json:"Plan"`
+			}
+			raw []byte
+		)
+
+		rawSql, args, err := b.ToSql()
+		if err != nil {
+			return 0, err
+		}
+
+		if err = tx.QueryRowContext(ctx, "EXPLAIN (FORMAT JSON) "+rawSql, args...).Scan(&raw); err != nil {
+			return 0, err
+		}
+
+		if err = json.Unmarshal(raw, &plan); err != nil || len(plan) == 0 {
+			return 0, fmt.Errorf("не удалось разобрать план запроса")
+		}
+
+		return uint64(plan[0].Plan.Rows), nil
+
+	default:
+		return 0, fmt.Errorf("неизвестный драйвер %s", db.Config.Driver)
+	}
+}
+
+// scanRow сканирует строку результата: значения видимых столбцов возвращаются по порядку,
+// а служебные попадают в hidden или отбрасываются.
+func scanRow(rows *sql.Rows, types []*sql.ColumnType, hidden map[string]any, visible int) ([]any, error) {
+	dest, values := make([]any, len(types)), make([]any, 0, visible)
+
+	for i, t := range types {
+		if strings.HasPrefix(t.Name(), specialRootPrefix) {
+			if dest[i] = hidden[t.Name()]; dest[i] == nil {
+				dest[i] = new(any)
+			}
+			continue
+		}
+
+		values = append(values, nil)
+		dest[i] = &values[len(values)-1]
+	}
+
+	return values, rows.Scan(dest...)
+}
+
+// sizeOf - приблизительный объем значения в байтах.
+func sizeOf(value any) uint64 {
+	switch v := value.(type) {
+	case []byte:
+		return uint64(len(v))
+	case string:
+		return uint64(len(v))
+	default:
+		return 8
+	}
+}
+
+func (db *Database) parseSelect(ctx context.Context, query Query) (sq.SelectBuilder, map[string][]string, error) {
 	b := db.Builder.
 		Select().
 		From(query.Table.Full())
@@ -511,7 +1127,7 @@ func (db *Database) parseSelect(query Query) (sq.SelectBuilder, map[string][]str
 	if pKey == nil && !hasFunc {
 		var table *Table
 
-		if table, err = db.GetTable(context.Background(), query.Table.Name); err != nil {
+		if table, err = db.table(ctx, query.Table.Name); err != nil {
 			return sq.SelectBuilder{}, nil, err
 		}
 
@@ -520,7 +1136,10 @@ func (db *Database) parseSelect(query Query) (sq.SelectBuilder, map[string][]str
 		}
 	}
 
-	if pKey != nil && !hasFunc {
+	//постраничное чтение по ключу возможно только для строк таблицы с первичным ключом.
+	keyset := pKey != nil && !hasFunc
+
+	if keyset {
 		b = b.Columns(fmt.Sprintf(
 			`%s "%s"`, pKey.Partial(), specialRootId,
 		))
@@ -530,21 +1149,63 @@ func (db *Database) parseSelect(query Query) (sq.SelectBuilder, map[string][]str
 		b = b.GroupBy(groupBy...)
 	}
 
-	for _, column := range query.OrderBy {
+	var (
+		orderBy = make([]string, 0, len(query.OrderBy)+1)
+		desc    = make([]bool, 0, len(query.OrderBy)+1)
+	)
+
+	for i, column := range query.OrderBy {
 		order, ok := column.Payload[Order]
 		if !ok || (order != "ASC" && order != "DESC") {
 			order = "ASC"
 		}
 
 		b = b.OrderBy(fmt.Sprintf("%s %s", column.Partial(), order))
+
+		if keyset {
+			b = b.Columns(fmt.Sprintf(`%s "%s%d"`, column.Partial(), specialKeyPrefix, i))
+		}
+
+		orderBy, desc = append(orderBy, column.Partial()), append(desc, order == "DESC")
+	}
+
+	//первичный ключ делает порядок строк между страницами однозначным.
+	if keyset && (query.Limit != 0 || len(query.Cursor) != 0) {
+		b = b.OrderBy(pKey.Partial() + " ASC")
+		orderBy, desc = append(orderBy, pKey.Partial()), append(desc, false)
 	}
 
 	for _, column := range query.Where {
-		if column.Value != nil {
-			b = b.Where(sq.Eq{column.Partial(): column.Value})
+		var condition sq.Sqlizer
+
+		if condition, err = column.condition(); err != nil {
+			return b, nil, err
+		}
+
+		if condition != nil {
+			b = b.Where(condition)
 		}
 	}
 
+	if len(query.Cursor) != 0 {
+		if !keyset {
+			return b, nil, fmt.Errorf("курсор не поддерживается для запросов с агрегатными функциями или без первичного ключа")
+		}
+
+		c, err := decodeCursor(query.Cursor)
+		if err != nil {
+			return b, nil, err
+		}
+
+		if len(c.Keys) != len(query.OrderBy) {
+			return b, nil, fmt.Errorf("курсор не соответствует сортировке запроса")
+		}
+
+		b = b.Where(keysetWhere(orderBy, desc, append(c.Keys, c.Id)))
+
+		query.Offset = 0
+	}
+
 	if query.Limit != 0 {
 		b = b.
 			Limit(query.Limit).
@@ -554,19 +1215,34 @@ func (db *Database) parseSelect(query Query) (sq.SelectBuilder, map[string][]str
 	return b, rules, nil
 }
 
-func (db *Database) executeInsert(ctx context.Context, query Query) QResponse {
+func (db *Database) executeInsert(ctx context.Context, tx *sql.Tx, query Query) QResponse {
 	b, err := db.parseInsert(query)
 	if err != nil {
 		return QResponse{}.errParse(err)
 	}
 
-	if _, err = b.ExecContext(ctx); err != nil {
+	var rows *sql.Rows
+
+	if rows, err = b.Suffix("RETURNING *").RunWith(tx).QueryContext(ctx); err != nil {
 		return QResponse{}.errExecute(err)
 	}
+	defer func() { _ = rows.Close() }()
 
-	rawSql, _ := b.MustSql()
+	var (
+		count int64
+		after []map[string]any
+	)
+
+	if count, after, err = scanSnapshot(rows); err != nil {
+		return QResponse{}.errExecute(err)
+	}
 
-	return QResponse{RawSql: rawSql}
+	rawSql, args := b.MustSql()
+
+	return QResponse{
+		RawSql:   rawSql,
+		Mutation: db.newMutation(ctx, query, rawSql, args, count, nil, after),
+	}
 }
 
 func (db *Database) parseInsert(query Query) (sq.InsertBuilder, error) {
@@ -582,19 +1258,45 @@ func (db *Database) parseInsert(query Query) (sq.InsertBuilder, error) {
 	return b.Values(values...), nil
 }
 
-func (db *Database) executeUpdate(ctx context.Context, query Query) QResponse {
+func (db *Database) executeUpdate(ctx context.Context, tx *sql.Tx, query Query) QResponse {
 	b, err := db.parseUpdate(query)
 	if err != nil {
 		return QResponse{}.errParse(err)
 	}
 
-	if _, err = b.ExecContext(ctx); err != nil {
+	var before []map[string]any
+
+	if before, err = db.selectSnapshot(ctx, tx, query); err != nil {
 		return QResponse{}.errExecute(err)
 	}
 
-	rawSql, _ := b.MustSql()
+	var rows *sql.Rows
+
+	if rows, err = b.Suffix("RETURNING *").RunWith(tx).QueryContext(ctx); err != nil {
+		return QResponse{}.errExecute(err)
+	}
+
+	var (
+		count int64
+		after []map[string]any
+	)
+
+	count, after, err = scanSnapshot(rows)
+	_ = rows.Close()
+	if err != nil {
+		return QResponse{}.errExecute(err)
+	}
 
-	return QResponse{RawSql: rawSql}
+	if count > snapshotLimit {
+		before = nil
+	}
+
+	rawSql, args := b.MustSql()
+
+	return QResponse{
+		RawSql:   rawSql,
+		Mutation: db.newMutation(ctx, query, rawSql, args, count, before, after),
+	}
 }
 
 func (db *Database) parseUpdate(query Query) (sq.UpdateBuilder, error) {
@@ -604,38 +1306,237 @@ func (db *Database) parseUpdate(query Query) (sq.UpdateBuilder, error) {
 		b = b.Set(strconv.Quote(column.Name), column.Value)
 	}
 
-	for _, column := range query.Where {
-		if column.Value != nil {
-			b = b.Where(sq.Eq{strconv.Quote(column.Name): column.Value})
-		}
+	where, err := parseWriteWhere(query)
+	if err != nil || len(where) == 0 {
+		return b, err
 	}
 
-	return b, nil
+	return b.Where(where), nil
 }
 
-func (db *Database) executeDelete(ctx context.Context, query Query) QResponse {
+func (db *Database) executeDelete(ctx context.Context, tx *sql.Tx, query Query) QResponse {
 	b, err := db.parseDelete(query)
 	if err != nil {
 		return QResponse{}.errParse(err)
 	}
 
-	if _, err = b.ExecContext(ctx); err != nil {
+	var rows *sql.Rows
+
+	if rows, err = b.Suffix("RETURNING *").RunWith(tx).QueryContext(ctx); err != nil {
 		return QResponse{}.errExecute(err)
 	}
+	defer func() { _ = rows.Close() }()
 
-	rawSql, _ := b.MustSql()
+	var (
+		count  int64
+		before []map[string]any
+	)
+
+	if count, before, err = scanSnapshot(rows); err != nil {
+		return QResponse{}.errExecute(err)
+	}
 
-	return QResponse{RawSql: rawSql}
+	rawSql, args := b.MustSql()
+
+	return QResponse{
+		RawSql:   rawSql,
+		Mutation: db.newMutation(ctx, query, rawSql, args, count, before, nil),
+	}
 }
 
 func (db *Database) parseDelete(query Query) (sq.DeleteBuilder, error) {
 	b := db.Builder.Delete(query.Table.Partial())
 
+	where, err := parseWriteWhere(query)
+	if err != nil || len(where) == 0 {
+		return b, err
+	}
+
+	return b.Where(where), nil
+}
+
+// parseWriteWhere - условия отбора для update и delete.
+// null преобразуется в IS NULL, а запрос без условий со значениями
+// отклоняется, если явно не указан allowFullTable.
+func parseWriteWhere(query Query) (sq.And, error) {
+	if err := checkWriteOperators(query); err != nil {
+		return nil, err
+	}
+
+	var (
+		where    = make(sq.And, 0, len(query.Where))
+		hasValue bool
+	)
+
 	for _, column := range query.Where {
+		if len(column.TableKey.Name) != 0 && column.TableKey.Name != query.Table.Name {
+			return nil, fmt.Errorf("столбец %s не принадлежит таблице %s", column.Name, query.Table.Name)
+		}
+
 		if column.Value != nil {
-			b = b.Where(sq.Eq{strconv.Quote(column.Name): column.Value})
+			hasValue = true
 		}
+
+		where = append(where, sq.Eq{
+			fmt.Sprintf(`%s."%s"`, query.Table.Partial(), column.Name): column.Value,
+		})
 	}
 
-	return b, nil
+	if !hasValue && !query.AllowFullTable {
+		return nil, fmt.Errorf("запрос изменяет всю таблицу %s, укажите условия или allowFullTable", query.Table.Name)
+	}
+
+	return where, nil
+}
+
+// checkWriteOperators отклоняет операторы в условиях update и delete: их условия - только равенства,
+// и оператор, который молча не применился бы, изменил бы другие строки.
+func checkWriteOperators(query Query) error {
+	if query.Type != Update && query.Type != Delete {
+		return nil
+	}
+
+	for _, c := range query.Where {
+		if c == nil {
+			continue
+		}
+
+		if _, ok := c.Payload[Operator]; ok {
+			return fmt.Errorf("оператор условия столбца %s допустим только в select", c.Name)
+		}
+	}
+
+	return nil
+}
+
+// snapshotLimit - максимальное количество строк в снимке для журнала аудита.
+const snapshotLimit = 1000
+
+// selectSnapshot блокирует и возвращает строки, которые изменит update.
+func (db *Database) selectSnapshot(ctx context.Context, tx *sql.Tx, query Query) ([]map[string]any, error) {
+	where, err := parseWriteWhere(query)
+	if err != nil {
+		return nil, err
+	}
+
+	b := db.Builder.
+		Select("*").
+		From(query.Table.Partial()).
+		Limit(snapshotLimit + 1).
+		Suffix("FOR UPDATE").
+		RunWith(tx)
+
+	if len(where) != 0 {
+		b = b.Where(where)
+	}
+
+	var rows *sql.Rows
+
+	if rows, err = b.QueryContext(ctx); err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var snapshot []map[string]any
+
+	if _, snapshot, err = scanSnapshot(rows); err != nil {
+		return nil, err
+	}
+
+	return snapshot, nil
+}
+
+// scanSnapshot возвращает количество строк и не более snapshotLimit из них.
+func scanSnapshot(rows *sql.Rows) (int64, []map[string]any, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var (
+		count    int64
+		snapshot []map[string]any
+	)
+
+	for rows.Next() {
+		count++
+
+		if count > snapshotLimit {
+			continue
+		}
+
+		dest := make([]any, len(columns))
+		for i := range dest {
+			dest[i] = new(any)
+		}
+
+		if err = rows.Scan(dest...); err != nil {
+			return 0, nil, err
+		}
+
+		item := make(map[string]any, len(columns))
+		for i, c := range columns {
+			value := *dest[i].(*any)
+			if b, ok := value.([]byte); ok {
+				value = string(b)
+			}
+			item[c] = value
+		}
+
+		snapshot = append(snapshot, item)
+	}
+
+	if count > snapshotLimit {
+		snapshot = nil
+	}
+
+	return count, snapshot, rows.Err()
+}
+
+func (db *Database) newMutation(
+	ctx context.Context,
+	query Query,
+	rawSql string,
+	args []any,
+	count int64,
+	before, after []map[string]any,
+) *Mutation {
+	m := Mutation{
+		Type:         query.Type,
+		Table:        query.Table.Name,
+		RawSql:       rawSql,
+		Args:         args,
+		RowsAffected: count,
+	}
+
+	if before == nil && after == nil {
+		return &m
+	}
+
+	table, err := db.table(ctx, query.Table.Name)
+	if err != nil {
+		return &m
+	}
+
+	pKey, ok := table.GetPKey()
+	if !ok {
+		return &m
+	}
+
+	m.Before, m.After = byPKey(before, pKey.Name), byPKey(after, pKey.Name)
+
+	return &m
+}
+
+func byPKey(snapshot []map[string]any, pKey string) map[string]map[string]any {
+	if snapshot == nil {
+		return nil
+	}
+
+	result := make(map[string]map[string]any, len(snapshot))
+	for _, item := range snapshot {
+		result[fmt.Sprint(item[pKey])] = item
+	}
+
+	return result
 }
@@ -0,0 +1,293 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+func testDatabase() *Database {
+	return &Database{Builder: sq.StatementBuilder.PlaceholderFormat(sq.Question)}
+}
+
+func where(values ...any) []*QColumn {
+	names := []string{"id", "region", "status"}
+
+	where := make([]*QColumn, len(values))
+	for i, v := range values {
+		where[i] = &QColumn{Column: Column{Name: names[i]}, Value: v}
+	}
+
+	return where
+}
+
+func TestParseInsert(t *testing.T) {
+	query := Query{
+		Type:  Insert,
+		Table: &QTable{QTableKey: QTableKey{Name: "users"}},
+		Columns: []*QColumn{
+			{Column: Column{Name: "name"}, Value: "Иван"},
+			{Column: Column{Name: "deleted_at"}, Value: nil},
+		},
+	}
+
+	b, err := testDatabase().parseInsert(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rawSql, args, err := b.ToSql()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := `INSERT INTO "users" ("name","deleted_at") VALUES (?,?)`; rawSql != want {
+		t.Errorf("sql = %s, want %s", rawSql, want)
+	}
+
+	//null в insert записывается как значение, а не отбрасывается.
+	if want := []any{"Иван", nil}; !reflect.DeepEqual(args, want) {
+		t.Errorf("args = %v, want %v", args, want)
+	}
+}
+
+func TestParseWrite(t *testing.T) {
+	tests := []struct {
+		name           string
+		where          []*QColumn
+		allowFullTable bool
+		wantWhere      string //ожидаемая часть после WHERE, пусто - без WHERE.
+		wantArgs       []any
+		wantErr        string
+	}{
+		{
+			name:    "без условий",
+			wantErr: "всю таблицу",
+		},
+		{
+			name:    "все условия null",
+			where:   where(nil, nil),
+			wantErr: "всю таблицу",
+		},
+		{
+			name:           "без условий с allowFullTable",
+			allowFullTable: true,
+		},
+		{
+			name:           "все условия null с allowFullTable",
+			where:          where(nil),
+			allowFullTable: true,
+			wantWhere:      `("users"."id" IS NULL)`,
+		},
+		{
+			name:      "null рядом со значением",
+			where:     where(5, nil),
+			wantWhere: `("users"."id" = ? AND "users"."region" IS NULL)`,
+			wantArgs:  []any{5},
+		},
+		{
+			name:      "массив значений",
+			where:     where([]any{1, 2}),
+			wantWhere: `("users"."id" IN (?,?))`,
+			wantArgs:  []any{1, 2},
+		},
+		{
+			name: "столбец другой таблицы",
+			where: []*QColumn{{
+				Column:   Column{Name: "id"},
+				TableKey: QTableKey{Name: "orders"},
+				Value:    1,
+			}},
+			wantErr: "не принадлежит таблице",
+		},
+		{
+			name: "оператор условия",
+			where: []*QColumn{{
+				Column:  Column{Name: "id"},
+				Value:   5,
+				Payload: map[string]any{Operator: OpGt},
+			}},
+			wantErr: "допустим только в select",
+		},
+	}
+
+	table := &QTable{QTableKey: QTableKey{Name: "users"}}
+	columns := []*QColumn{{Column: Column{Name: "status"}, Value: "blocked"}}
+
+	for _, tt := range tests {
+		for _, typ := range []string{Update, Delete} {
+			t.Run(typ+"/"+tt.name, func(t *testing.T) {
+				query := Query{
+					Type:           typ,
+					Table:          table,
+					Where:          tt.where,
+					AllowFullTable: tt.allowFullTable,
+				}
+
+				var (
+					b   interface{ ToSql() (string, []any, error) }
+					err error
+
+					prefix = `DELETE FROM "users"`
+					args   []any
+				)
+
+				if typ == Update {
+					query.Columns = columns
+					prefix, args = `UPDATE "users" SET "status" = ?`, []any{"blocked"}
+
+					b, err = testDatabase().parseUpdate(query)
+				} else {
+					b, err = testDatabase().parseDelete(query)
+				}
+
+				if len(tt.wantErr) != 0 {
+					if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+						t.Fatalf("err = %v, want %q", err, tt.wantErr)
+					}
+					return
+				}
+
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				rawSql, gotArgs, err := b.ToSql()
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				want := prefix
+				if len(tt.wantWhere) != 0 {
+					want += " WHERE " + tt.wantWhere
+				}
+
+				if rawSql != want {
+					t.Errorf("sql = %s, want %s", rawSql, want)
+				}
+
+				if want := append(args, tt.wantArgs...); len(gotArgs)+len(want) != 0 && !reflect.DeepEqual(gotArgs, want) {
+					t.Errorf("args = %v, want %v", gotArgs, want)
+				}
+			})
+		}
+	}
+}
+
+// ссылка на пароль вне разрешенных отклоняется до подключения.
+func TestPasswordRefRejected(t *testing.T) {
+	cfg := Config{Driver: PostgreSQL, Host: "127.0.0.1", Port: 5432, PasswordRef: "env:HOME"}
+
+	if _, _, err := cfg.Connector(); err == nil || !strings.Contains(err.Error(), "недопустимая ссылка") {
+		t.Fatalf("Connector err = %v", err)
+	}
+
+	d := Diagnose(context.Background(), cfg)
+
+	if d.Ok || len(d.Steps) == 0 || d.Steps[0].Status != StepFailed {
+		t.Fatalf("diagnosis = %+v, want first step failed", d)
+	}
+}
+
+// необязательный параметр без значения не превращает условие изменения в IS NULL.
+func TestBindWrite(t *testing.T) {
+	for _, typ := range []string{Select, Update, Delete} {
+		query := Query{
+			Type:       typ,
+			Table:      &QTable{QTableKey: QTableKey{Name: "users"}},
+			Where:      where("{{region}}"),
+			Parameters: []Parameter{{Name: "region"}},
+		}
+
+		_, err := query.Bind(nil)
+
+		if typ == Select && err != nil {
+			t.Errorf("%s: err = %v", typ, err)
+		}
+
+		if typ != Select && (err == nil || !strings.Contains(err.Error(), "region")) {
+			t.Errorf("%s: err = %v, want missing value error", typ, err)
+		}
+
+		bound, err := query.Bind(map[string]any{"region": "north"})
+		if err != nil {
+			t.Fatalf("%s: err = %v", typ, err)
+		}
+
+		if bound.Where[0].Value != "north" {
+			t.Errorf("%s: value = %v", typ, bound.Where[0].Value)
+		}
+	}
+}
+
+func TestValidateWriteOperator(t *testing.T) {
+	query := Query{
+		Type:  Delete,
+		Table: &QTable{QTableKey: QTableKey{Name: "users"}},
+		Where: []*QColumn{{
+			Column:  Column{Name: "id"},
+			Value:   5,
+			Payload: map[string]any{Operator: OpLt},
+		}},
+	}
+
+	if err := testDatabase().Validate(context.Background(), query); err == nil || !strings.Contains(err.Error(), "только в select") {
+		t.Fatalf("err = %v, want operator error", err)
+	}
+}
+
+// целые параметры не округляются до float64.
+func TestBindNumber(t *testing.T) {
+	query := Query{
+		Type:       Select,
+		Table:      &QTable{QTableKey: QTableKey{Name: "users"}},
+		Where:      where("{{id}}"),
+		Parameters: []Parameter{{Name: "id", Type: NumberJSON, Allowed: []any{"9007199254740993", 1.5, 2.0}}},
+	}
+
+	tests := []struct {
+		value any
+		want  any
+	}{
+		{value: "9007199254740993", want: int64(9007199254740993)},
+		{value: json.Number("9007199254740993"), want: int64(9007199254740993)},
+		{value: 2.0, want: int64(2)},
+		{value: "2", want: int64(2)},
+		{value: "1.5", want: 1.5},
+	}
+
+	for _, tt := range tests {
+		bound, err := query.Bind(map[string]any{"id": tt.value})
+		if err != nil {
+			t.Fatalf("%v: err = %v", tt.value, err)
+		}
+
+		if bound.Where[0].Value != tt.want {
+			t.Errorf("%v: value = %#v, want %#v", tt.value, bound.Where[0].Value, tt.want)
+		}
+	}
+
+	//соседнее целое после округления до float64 совпало бы с допустимым.
+	if _, err := query.Bind(map[string]any{"id": "9007199254740992"}); err == nil {
+		t.Error("9007199254740992 matched allowed 9007199254740993")
+	}
+
+	query.Parameters[0].Allowed = nil
+
+	bound, err := query.Bind(map[string]any{"id": "18446744073709551617"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bound.Where[0].Value != json.Number("18446744073709551617") {
+		t.Errorf("value = %#v", bound.Where[0].Value)
+	}
+
+	if _, err = query.Bind(map[string]any{"id": "one"}); err == nil {
+		t.Error("non-number accepted")
+	}
+}
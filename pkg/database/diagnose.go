@@ -0,0 +1,303 @@
+package database
+
+import (
+	"context"
+	"crypto/x509"
+	"database/sql"
+	"datapointbackend/pkg/secret"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// шаги проверки подключения в порядке исполнения.
+const (
+	StepDNS         = "dns"
+	StepTCP         = "tcp"
+	StepTLS         = "tls"
+	StepAuth        = "auth"
+	StepDatabase    = "database"
+	StepPermissions = "permissions"
+)
+
+// результаты шага проверки подключения.
+const (
+	StepOk      = "ok"
+	StepWarning = "warning" //подключение возможно, но стоит обратить внимание.
+	StepFailed  = "failed"
+	StepSkipped = "skipped" //не исполнялся из-за ошибки предыдущего шага.
+)
+
+type Step struct {
+	Name    string  `json:"name"`
+	Status  string  `json:"status"`
+	Latency float64 `json:"latency"` //в миллисекундах.
+	Error   string  `json:"error"`
+	Hint    string  `json:"hint"` //что проверить пользователю.
+}
+
+type Diagnosis struct {
+	Ok    bool   `json:"ok"` //все шаги выполнены без ошибок.
+	Steps []Step `json:"steps"`
+}
+
+// Diagnose по шагам проверяет, можно ли подключиться к источнику, ничего не сохраняя.
+func Diagnose(ctx context.Context, cfg Config) Diagnosis {
+	var d diagnosis
+
+	d.run(StepDNS, func() (string, string, error) {
+		if _, _, _, err := cfg.Build(); err != nil {
+			return StepFailed, "выберите поддерживаемый драйвер", err
+		}
+
+		if len(cfg.PasswordRef) != 0 {
+			if err := secret.Validate(cfg.PasswordRef); err != nil {
+				return StepFailed, "укажите ссылку на разрешенную переменную окружения или файл каталога секретов", err
+			}
+		}
+
+		if net.ParseIP(cfg.Host) != nil {
+			return StepOk, "", nil
+		}
+
+		if cfg.Dialer != nil {
+			return StepOk, "имя разрешается на стороне туннеля", nil
+		}
+
+		addrs, err := net.DefaultResolver.LookupHost(ctx, cfg.Host)
+		if err != nil {
+			return StepFailed, fmt.Sprintf("проверьте имя хоста %s, оно не найдено в DNS", cfg.Host), err
+		}
+
+		return StepOk, fmt.Sprintf("адреса: %v", addrs), nil
+	})
+
+	var conn net.Conn
+
+	d.run(StepTCP, func() (string, string, error) {
+		var (
+			dialer Dialer = &net.Dialer{}
+			hint          = fmt.Sprintf(
+				"проверьте, что сервер запущен и принимает подключения на порту %d и сетевые правила разрешают подключение", cfg.Port,
+			)
+			err error
+		)
+
+		if cfg.Dialer != nil {
+			dialer = cfg.Dialer
+			hint = "проверьте настройки SSH-туннеля и что сервер доступен с промежуточного хоста"
+		}
+
+		if conn, err = dialer.DialContext(ctx, "tcp", net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))); err != nil {
+			return StepFailed, hint, err
+		}
+
+		return StepOk, "", nil
+	})
+
+	d.run(StepTLS, func() (string, string, error) {
+		defer func() { _ = conn.Close() }()
+
+		if deadline, ok := ctx.Deadline(); ok {
+			_ = conn.SetDeadline(deadline)
+		}
+
+		if cfg.TLS.enabled() {
+			return diagnoseTLS(ctx, conn, cfg)
+		}
+
+		supported, err := sslRequest(conn)
+		if err != nil {
+			return StepFailed, "сервер ответил не как PostgreSQL, проверьте порт", err
+		}
+
+		if supported {
+			return StepWarning, "TLS отключен в настройках источника, хотя сервер его поддерживает", nil
+		}
+
+		return StepWarning, "сервер не поддерживает TLS, соединение не шифруется", nil
+	})
+
+	var db *sql.DB
+
+	d.run(StepAuth, func() (string, string, error) {
+		connector, _, err := cfg.Connector()
+		if err != nil {
+			return StepFailed, "", err
+		}
+
+		db = sql.OpenDB(connector)
+		db.SetMaxOpenConns(1)
+
+		err = db.PingContext(ctx)
+
+		var pqErr *pq.Error
+
+		switch {
+		case err == nil:
+			return StepOk, "", nil
+
+		//база данных проверяется после аутентификации.
+		case errors.As(err, &pqErr) && pqErr.Code == "3D000":
+			return StepOk, "", nil
+
+		case errors.As(err, &pqErr) && (pqErr.Code == "28P01" || pqErr.Code == "28000"):
+			return StepFailed, "проверьте имя пользователя и пароль, а также правила pg_hba.conf для этого адреса", err
+
+		default:
+			return StepFailed, "", err
+		}
+	})
+
+	if db != nil {
+		defer func() { _ = db.Close() }()
+	}
+
+	d.run(StepDatabase, func() (string, string, error) {
+		err := db.PingContext(ctx)
+
+		var pqErr *pq.Error
+
+		if errors.As(err, &pqErr) && pqErr.Code == "3D000" {
+			return StepFailed, fmt.Sprintf("база данных %s не существует, проверьте ее имя", cfg.DatabaseName), err
+		}
+
+		if err != nil {
+			return StepFailed, "", err
+		}
+
+		return StepOk, "", nil
+	})
+
+	d.run(StepPermissions, func() (string, string, error) {
+		var (
+			usage  bool
+			tables int
+		)
+
+		if err := db.QueryRowContext(ctx, `
+			SELECT has_schema_privilege('public', 'USAGE'),
+			       (SELECT count(*) FROM information_schema.tables WHERE table_schema = 'public')
+		`).Scan(&usage, &tables); err != nil {
+			return StepFailed, "пользователю нужно право чтения information_schema", err
+		}
+
+		if !usage {
+			return StepFailed, "выдайте пользователю право USAGE на схему public", nil
+		}
+
+		if tables == 0 {
+			return StepWarning, "пользователь не видит ни одной таблицы в схеме public, проверьте права SELECT", nil
+		}
+
+		return StepOk, fmt.Sprintf("доступно таблиц: %d", tables), nil
+	})
+
+	return d.result()
+}
+
+// diagnoseTLS выполняет рукопожатие TLS с настройками источника и объясняет ошибки проверки сертификатов.
+func diagnoseTLS(ctx context.Context, conn net.Conn, cfg Config) (string, string, error) {
+	tlsCfg, err := cfg.TLS.config(cfg.Host)
+	if err != nil {
+		return StepFailed, "проверьте сертификаты и ключ в формате PEM", err
+	}
+
+	if _, err = startTLS(ctx, conn, tlsCfg); err == nil {
+		return StepOk, "", nil
+	}
+
+	var (
+		unknownAuthority x509.UnknownAuthorityError
+		hostname         x509.HostnameError
+		invalid          x509.CertificateInvalidError
+	)
+
+	switch {
+	case errors.As(err, &unknownAuthority):
+		return StepFailed, "сертификат сервера не подписан указанным CA, проверьте sslRootCert", err
+	case errors.As(err, &hostname):
+		return StepFailed, "сертификат сервера выдан на другое имя, укажите его в sslServerName или используйте verify-ca", err
+	case errors.As(err, &invalid):
+		return StepFailed, "сертификат сервера недействителен, например истек срок его действия", err
+	default:
+		return StepFailed, "проверьте, что сервер принимает TLS и требует ли он сертификат клиента", err
+	}
+}
+
+// Rejected возвращает результат проверки, которая не началась, потому что конфигурация отклонена.
+func Rejected(hint string, err error) Diagnosis {
+	var d diagnosis
+
+	d.run(StepDNS, func() (string, string, error) {
+		return StepFailed, hint, err
+	})
+
+	for _, name := range []string{StepTCP, StepTLS, StepAuth, StepDatabase, StepPermissions} {
+		d.run(name, nil)
+	}
+
+	return d.result()
+}
+
+type diagnosis struct {
+	steps  []Step
+	failed bool
+}
+
+// run исполняет шаг, если предыдущие шаги не завершились ошибкой.
+func (d *diagnosis) run(name string, step func() (status, hint string, err error)) {
+	if d.failed {
+		d.steps = append(d.steps, Step{Name: name, Status: StepSkipped})
+		return
+	}
+
+	started := time.Now()
+
+	status, hint, err := step()
+
+	s := Step{Name: name, Status: status, Latency: milliseconds(time.Since(started)), Hint: hint}
+	if err != nil {
+		s.Error = err.Error()
+	}
+
+	if status == StepFailed {
+		d.failed = true
+	}
+
+	d.steps = append(d.steps, s)
+}
+
+func (d *diagnosis) result() Diagnosis {
+	return Diagnosis{Ok: !d.failed, Steps: d.steps}
+}
+
+// sslRequest спрашивает у сервера PostgreSQL, поддерживает ли он TLS.
+func sslRequest(conn net.Conn) (bool, error) {
+	request := make([]byte, 8)
+	binary.BigEndian.PutUint32(request, 8)
+	binary.BigEndian.PutUint32(request[4:], 80877103)
+
+	if _, err := conn.Write(request); err != nil {
+		return false, err
+	}
+
+	response := make([]byte, 1)
+	if _, err := io.ReadFull(conn, response); err != nil {
+		return false, err
+	}
+
+	switch response[0] {
+	case 'S':
+		return true, nil
+	case 'N':
+		return false, nil
+	default:
+		return false, fmt.Errorf("неожиданный ответ на запрос TLS: %q", response[0])
+	}
+}
@@ -0,0 +1,417 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	sq "github.com/Masterminds/squirrel"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Parameter - объявление параметра запроса. Значения условий where ссылаются на параметр в виде {{name}},
+// значения подставляются в запрос только аргументами.
+type Parameter struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`     //логический тип: number, string, boolean, date или datetime, пусто - любой.
+	Default  any    `json:"default"`  //значение, если параметр не передан.
+	Required bool   `json:"required"` //без значения запрос не исполняется, иначе условие без значения не применяется. В update и delete значение нужно всегда.
+	Allowed  []any  `json:"allowed"`  //допустимые значения, пусто - любые.
+}
+
+// операторы условий where, указываются в Payload столбца под ключом Operator, по умолчанию =.
+// Допустимы только в select, в update и delete отклоняются.
+const (
+	Operator = "operator"
+
+	OpEq      = "="
+	OpNotEq   = "!="
+	OpLt      = "<"
+	OpLtOrEq  = "<="
+	OpGt      = ">"
+	OpGtOrEq  = ">="
+	OpBetween = "between" //значение - массив из двух границ, null - граница не ограничена.
+	OpLike    = "like"
+	OpILike   = "ilike"
+)
+
+func (c *QColumn) operator() (string, error) {
+	op, ok := c.Payload[Operator]
+	if !ok {
+		return OpEq, nil
+	}
+
+	switch op {
+	case OpEq, OpNotEq, OpLt, OpLtOrEq, OpGt, OpGtOrEq, OpBetween, OpLike, OpILike:
+		return op.(string), nil
+	default:
+		return "", fmt.Errorf("неизвестный оператор условия %v столбца %s", op, c.Name)
+	}
+}
+
+// condition возвращает условие where столбца, nil - условие не применяется, потому что нет значения.
+func (c *QColumn) condition() (sq.Sqlizer, error) {
+	op, err := c.operator()
+	if err != nil || c.Value == nil {
+		return nil, err
+	}
+
+	expr := c.Partial()
+
+	switch op {
+	case OpNotEq:
+		return sq.NotEq{expr: c.Value}, nil
+	case OpLt:
+		return sq.Lt{expr: c.Value}, nil
+	case OpLtOrEq:
+		return sq.LtOrEq{expr: c.Value}, nil
+	case OpGt:
+		return sq.Gt{expr: c.Value}, nil
+	case OpGtOrEq:
+		return sq.GtOrEq{expr: c.Value}, nil
+	case OpLike:
+		return sq.Like{expr: c.Value}, nil
+	case OpILike:
+		return sq.ILike{expr: c.Value}, nil
+	case OpBetween:
+		bounds, ok := c.Value.([]any)
+		if !ok || len(bounds) != 2 {
+			return nil, fmt.Errorf("значение between столбца %s должно быть массивом из двух границ", c.Name)
+		}
+
+		and := make(sq.And, 0, 2)
+		if bounds[0] != nil {
+			and = append(and, sq.GtOrEq{expr: bounds[0]})
+		}
+		if bounds[1] != nil {
+			and = append(and, sq.LtOrEq{expr: bounds[1]})
+		}
+
+		if len(and) == 0 {
+			return nil, nil
+		}
+
+		return and, nil
+	default:
+		//массив значений преобразуется в IN.
+		return sq.Eq{expr: c.Value}, nil
+	}
+}
+
+// Bind подставляет значения параметров в условия where и limit и возвращает запрос без параметров.
+// В запросе без параметров значения вида {{name}} не считаются ссылками.
+func (q Query) Bind(values map[string]any) (Query, error) {
+	if !q.parameterized() {
+		if len(values) != 0 {
+			return q, fmt.Errorf("у запроса нет параметров")
+		}
+		return q, nil
+	}
+
+	declared := make(map[string]bool, len(q.Parameters))
+	for _, p := range q.Parameters {
+		declared[p.Name] = true
+	}
+
+	for name := range values {
+		if !declared[name] {
+			return q, fmt.Errorf("неизвестный параметр %s", name)
+		}
+	}
+
+	bound := make(map[string]any, len(q.Parameters))
+
+	for _, p := range q.Parameters {
+		v := values[p.Name]
+		if v == nil {
+			v = p.Default
+		}
+
+		if v == nil {
+			if p.Required {
+				return q, fmt.Errorf("не передано значение обязательного параметра %s", p.Name)
+			}
+
+			//в update и delete условие без значения стало бы IS NULL и изменило бы другие строки.
+			if q.Type == Update || q.Type == Delete {
+				return q, fmt.Errorf("не передано значение параметра %s, без него изменение не исполняется", p.Name)
+			}
+
+			bound[p.Name] = nil
+
+			continue
+		}
+
+		var err error
+
+		if bound[p.Name], err = p.check(v); err != nil {
+			return q, fmt.Errorf("неверное значение параметра %s: %s", p.Name, err.Error())
+		}
+	}
+
+	return q.bind(bound)
+}
+
+func (q Query) parameterized() bool {
+	return len(q.Parameters) != 0 || len(q.LimitParam) != 0
+}
+
+// bind подставляет значения bound вместо ссылок на параметры.
+func (q Query) bind(bound map[string]any) (Query, error) {
+	where := make([]*QColumn, len(q.Where))
+
+	for i, c := range q.Where {
+		if c == nil {
+			return q, fmt.Errorf("пустой столбец в условиях запроса")
+		}
+
+		column := *c
+
+		var err error
+
+		if column.Value, err = bindValue(c.Value, bound); err != nil {
+			return q, err
+		}
+
+		where[i] = &column
+	}
+
+	q.Where = where
+
+	if len(q.LimitParam) != 0 {
+		v, ok := bound[q.LimitParam]
+		if !ok {
+			return q, fmt.Errorf("limit ссылается на необъявленный параметр %s", q.LimitParam)
+		}
+
+		if v != nil {
+			limit, err := toNumber(v)
+			if err != nil || limit < 0 || limit != math.Trunc(limit) {
+				return q, fmt.Errorf("значение limit из параметра %s должно быть неотрицательным целым", q.LimitParam)
+			}
+
+			q.Limit = uint64(limit)
+		}
+	}
+
+	q.Parameters, q.LimitParam = nil, ""
+
+	return q, nil
+}
+
+func bindValue(v any, bound map[string]any) (any, error) {
+	switch v := v.(type) {
+	case string:
+		name, ok := placeholder(v)
+		if !ok {
+			return v, nil
+		}
+
+		value, ok := bound[name]
+		if !ok {
+			return nil, fmt.Errorf("условие ссылается на необъявленный параметр %s", name)
+		}
+
+		return value, nil
+
+	case []any:
+		values := make([]any, len(v))
+		for i := range v {
+			var err error
+			if values[i], err = bindValue(v[i], bound); err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+
+	default:
+		return v, nil
+	}
+}
+
+// placeholder возвращает имя параметра, если s - ссылка вида {{name}}.
+func placeholder(s string) (string, bool) {
+	if !strings.HasPrefix(s, "{{") || !strings.HasSuffix(s, "}}") {
+		return "", false
+	}
+
+	return strings.TrimSpace(s[2 : len(s)-2]), true
+}
+
+// check приводит значение к типу параметра и проверяет, что оно допустимо.
+// Массив значений проверяется поэлементно.
+func (p Parameter) check(v any) (any, error) {
+	if values, ok := v.([]any); ok {
+		checked := make([]any, len(values))
+		for i := range values {
+			var err error
+			if checked[i], err = p.check(values[i]); err != nil {
+				return nil, err
+			}
+		}
+		return checked, nil
+	}
+
+	v, err := p.convert(v)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(p.Allowed) == 0 {
+		return v, nil
+	}
+
+	for _, allowed := range p.Allowed {
+		if a, err := p.convert(allowed); err == nil && fmt.Sprint(a) == fmt.Sprint(v) {
+			return v, nil
+		}
+	}
+
+	return nil, fmt.Errorf("значение %v не входит в список допустимых", v)
+}
+
+// convert приводит значение к типу параметра.
+func (p Parameter) convert(v any) (any, error) {
+	var err error
+
+	switch p.Type {
+	case "":
+
+	case NumberJSON:
+		v, err = exactNumber(v)
+
+	case StringJSON:
+		if _, ok := v.(string); !ok {
+			err = fmt.Errorf("ожидается строка")
+		}
+
+	case BooleanJSON:
+		switch b := v.(type) {
+		case bool:
+		case string:
+			v, err = strconv.ParseBool(b)
+		default:
+			err = fmt.Errorf("ожидается логическое значение")
+		}
+
+	case DateJSON, DateTimeJSON:
+		layout := time.RFC3339
+		if p.Type == DateJSON {
+			layout = time.DateOnly
+		}
+
+		if s, ok := v.(string); !ok {
+			err = fmt.Errorf("ожидается строка в формате %s", layout)
+		} else if _, err = time.Parse(layout, s); err != nil {
+			err = fmt.Errorf("ожидается строка в формате %s", layout)
+		}
+
+	default:
+		err = fmt.Errorf("неизвестный тип параметра %s", p.Type)
+	}
+
+	return v, err
+}
+
+// exactNumber приводит значение к числу без потери точности: целые остаются целыми,
+// а не помещающиеся в int64 передаются как json.Number. float64 - только для дробных значений.
+func exactNumber(v any) (any, error) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), nil
+	case int64:
+		return n, nil
+	case uint64:
+		if n > math.MaxInt64 {
+			return json.Number(strconv.FormatUint(n, 10)), nil
+		}
+		return int64(n), nil
+	case float64:
+		if n == math.Trunc(n) && math.Abs(n) < 1<<63 {
+			return int64(n), nil
+		}
+		return n, nil
+	case json.Number:
+		return parseNumber(string(n))
+	case string:
+		return parseNumber(n)
+	default:
+		return nil, fmt.Errorf("ожидается число")
+	}
+}
+
+// parseNumber разбирает число из текста, целое - без округления.
+func parseNumber(s string) (any, error) {
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return i, nil
+	}
+
+	if i, ok := new(big.Int).SetString(s, 10); ok {
+		return json.Number(i.String()), nil
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil, fmt.Errorf("ожидается число")
+	}
+
+	return f, nil
+}
+
+// toNumber приводит значение к float64 там, где точность целых больше 2^53 не нужна.
+func toNumber(v any) (float64, error) {
+	switch n := v.(type) {
+	case float64:
+		return n, nil
+	case int:
+		return float64(n), nil
+	case int64:
+		return float64(n), nil
+	case uint64:
+		return float64(n), nil
+	case json.Number:
+		return n.Float64()
+	case string:
+		return strconv.ParseFloat(n, 64)
+	default:
+		return 0, fmt.Errorf("ожидается число")
+	}
+}
+
+// checkParameters проверяет объявления параметров и ссылки на них
+// и возвращает запрос, в котором ссылки заменены пустыми значениями.
+func (q Query) checkParameters() (Query, error) {
+	if !q.parameterized() {
+		return q, nil
+	}
+
+	bound := make(map[string]any, len(q.Parameters))
+
+	for _, p := range q.Parameters {
+		if len(p.Name) == 0 {
+			return q, fmt.Errorf("у параметра запроса нет имени")
+		}
+
+		if _, ok := bound[p.Name]; ok {
+			return q, fmt.Errorf("параметр %s объявлен дважды", p.Name)
+		}
+
+		switch p.Type {
+		case "", NumberJSON, StringJSON, BooleanJSON, DateJSON, DateTimeJSON:
+		default:
+			return q, fmt.Errorf("неизвестный тип %s параметра %s", p.Type, p.Name)
+		}
+
+		if p.Default != nil {
+			if _, err := p.check(p.Default); err != nil {
+				return q, fmt.Errorf("неверное значение по умолчанию параметра %s: %s", p.Name, err.Error())
+			}
+		}
+
+		bound[p.Name] = nil
+	}
+
+	return q.bind(bound)
+}
@@ -0,0 +1,139 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	sq "github.com/Masterminds/squirrel"
+	"strings"
+	"time"
+)
+
+// Rows - строки select, которые читаются по одной, не загружаясь в память.
+type Rows struct {
+	Columns []ResultColumn //столбцы без служебных.
+
+	rows   *sql.Rows
+	tx     *sql.Tx //nil, если select исполняется без транзакции.
+	cancel context.CancelFunc
+
+	types   []*sql.ColumnType
+	visible int   //количество видимых столбцов, включая служебные с одним префиксом.
+	keep    []int //индексы видимых столбцов, которые попадают в Columns.
+	loc     *time.Location
+}
+
+// Open исполняет select и возвращает его строки для потоковой выгрузки.
+// Ограничения Limits не применяются, max - наибольшее количество строк, 0 - без ограничений.
+// Вызывающий обязан закрыть Rows.
+func (db *Database) Open(ctx context.Context, query Query, max uint64) (*Rows, error) {
+	if query.Type != Select {
+		return nil, fmt.Errorf("выгрузить можно только результат select")
+	}
+
+	loc, err := time.LoadLocation(query.TimeZone)
+	if err != nil {
+		return nil, err
+	}
+
+	if max != 0 && (query.Limit == 0 || query.Limit > max) {
+		query.Limit = max
+	}
+
+	b, _, err := db.parseSelect(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось разобрать запрос: %s", err.Error())
+	}
+
+	timeout := db.Config.Timeout(query.Timeout)
+
+	ctx, cancel := withTimeout(ctx, timeout)
+
+	r := Rows{cancel: cancel, loc: loc}
+
+	var runner sq.StdSqlCtx = db.Conn
+
+	if db.serverTimeout(timeout) {
+		if r.tx, err = db.begin(ctx, timeout); err != nil {
+			cancel()
+			return nil, fmt.Errorf("не удалось исполнить запрос: %s", err.Error())
+		}
+
+		runner = r.tx
+	}
+
+	if r.rows, err = b.RunWith(runner).QueryContext(ctx); err != nil {
+		_ = r.Close()
+		return nil, fmt.Errorf("не удалось исполнить запрос: %s", err.Error())
+	}
+
+	if r.types, err = r.rows.ColumnTypes(); err != nil {
+		_ = r.Close()
+		return nil, err
+	}
+
+	columns := newResultColumns(query, r.types)
+
+	r.visible = len(columns)
+
+	for i, c := range columns {
+		if !strings.HasPrefix(c.Name, specialPrefix) {
+			r.Columns = append(r.Columns, c)
+			r.keep = append(r.keep, i)
+		}
+	}
+
+	return &r, nil
+}
+
+func (r *Rows) Next() bool {
+	return r.rows.Next()
+}
+
+// Values возвращает значения текущей строки в порядке Columns, приведенные к значениям JSON.
+func (r *Rows) Values() ([]any, error) {
+	values, err := r.raw()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, value := range values {
+		values[i], _ = normalize(r.Columns[i].DbType, value, r.loc)
+	}
+
+	return values, nil
+}
+
+// raw возвращает значения драйвера текущей строки в порядке Columns.
+func (r *Rows) raw() ([]any, error) {
+	values, err := scanRow(r.rows, r.types, nil, r.visible)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]any, len(r.keep))
+	for i, index := range r.keep {
+		result[i] = values[index]
+	}
+
+	return result, nil
+}
+
+func (r *Rows) Err() error {
+	return r.rows.Err()
+}
+
+func (r *Rows) Close() error {
+	defer r.cancel()
+
+	var err error
+	if r.rows != nil {
+		err = r.rows.Close()
+	}
+
+	if r.tx != nil {
+		_ = r.tx.Rollback()
+	}
+
+	return err
+}
@@ -0,0 +1,159 @@
+package database
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// режимы TLS, как в libpq.
+const (
+	SSLDisable    = "disable"
+	SSLRequire    = "require"     //шифрование без проверки сертификата сервера.
+	SSLVerifyCA   = "verify-ca"   //сертификат сервера подписан SSLRootCert.
+	SSLVerifyFull = "verify-full" //и выдан на имя сервера.
+)
+
+// TLS - настройки шифрования подключения, сертификаты и ключ хранятся в формате PEM.
+type TLS struct {
+	SSLMode       string `json:"sslMode" yaml:"ssl_mode"` //по умолчанию disable.
+	SSLRootCert   string `json:"sslRootCert" yaml:"ssl_root_cert"`
+	SSLCert       string `json:"sslCert" yaml:"ssl_cert"`
+	SSLKey        string `json:"sslKey" yaml:"ssl_key"`
+	SSLServerName string `json:"sslServerName" yaml:"ssl_server_name"` //имя для проверки сертификата, по умолчанию host.
+}
+
+func (t TLS) enabled() bool {
+	return len(t.SSLMode) != 0 && t.SSLMode != SSLDisable
+}
+
+// config возвращает настройки crypto/tls для подключения к host.
+func (t TLS) config(host string) (*tls.Config, error) {
+	cfg := tls.Config{ServerName: host}
+
+	if len(t.SSLServerName) != 0 {
+		cfg.ServerName = t.SSLServerName
+	}
+
+	if len(t.SSLCert) != 0 || len(t.SSLKey) != 0 {
+		cert, err := tls.X509KeyPair([]byte(t.SSLCert), []byte(t.SSLKey))
+		if err != nil {
+			return nil, fmt.Errorf("не удалось прочитать сертификат и ключ клиента: %s", err.Error())
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	if len(t.SSLRootCert) != 0 {
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM([]byte(t.SSLRootCert)) {
+			return nil, fmt.Errorf("не удалось прочитать сертификаты CA")
+		}
+	}
+
+	switch t.SSLMode {
+	case SSLRequire:
+		cfg.InsecureSkipVerify = true
+
+	case SSLVerifyCA:
+		//crypto/tls не умеет проверять цепочку без имени, поэтому проверка выполняется вручную.
+		cfg.InsecureSkipVerify = true
+		cfg.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return verifyChain(rawCerts, cfg.RootCAs)
+		}
+
+	case SSLVerifyFull:
+
+	default:
+		return nil, fmt.Errorf("неизвестный режим TLS %s", t.SSLMode)
+	}
+
+	return &cfg, nil
+}
+
+func verifyChain(rawCerts [][]byte, roots *x509.CertPool) error {
+	if len(rawCerts) == 0 {
+		return errors.New("сервер не предъявил сертификат")
+	}
+
+	certs := make([]*x509.Certificate, len(rawCerts))
+	for i, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return err
+		}
+		certs[i] = cert
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	_, err := certs[0].Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates})
+
+	return err
+}
+
+// pqDialer подключает драйвер PostgreSQL через Dialer источника и устанавливает TLS сам,
+// чтобы применить настройки, которых нет в драйвере, например имя сервера для проверки.
+// Драйвер при этом подключается с sslmode=disable.
+type pqDialer struct {
+	dialer Dialer
+	tls    *tls.Config //nil - без TLS.
+}
+
+func (d *pqDialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+func (d *pqDialer) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return d.DialContext(ctx, network, address)
+}
+
+func (d *pqDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	conn, err := d.dialer.DialContext(ctx, network, address)
+	if err != nil || d.tls == nil {
+		return conn, err
+	}
+
+	var tc net.Conn
+
+	if tc, err = startTLS(ctx, conn, d.tls); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return tc, nil
+}
+
+// startTLS запрашивает у сервера PostgreSQL шифрование и выполняет рукопожатие TLS.
+func startTLS(ctx context.Context, conn net.Conn, cfg *tls.Config) (net.Conn, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+		defer func() { _ = conn.SetDeadline(time.Time{}) }()
+	}
+
+	supported, err := sslRequest(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	if !supported {
+		return nil, errors.New("сервер не поддерживает TLS")
+	}
+
+	tc := tls.Client(conn, cfg)
+
+	if err = tc.HandshakeContext(ctx); err != nil {
+		return nil, err
+	}
+
+	return tc, nil
+}
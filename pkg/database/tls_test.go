@@ -0,0 +1,252 @@
+package database
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCA - удостоверяющий центр, который выпускает сертификаты тестовых серверов.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  string
+}
+
+func newTestCA(t *testing.T) testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return testCA{
+		cert: cert,
+		key:  key,
+		pem:  string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+	}
+}
+
+// issue выпускает сертификат сервера на имя name.
+func (ca testCA) issue(t *testing.T, name string) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// serveTLS запускает сервер, который отвечает на SSLRequest как PostgreSQL: answer 'S' - и выполняет рукопожатие
+// с сертификатом cert, 'N' - и закрывает подключение. Возвращает адрес сервера.
+func serveTLS(t *testing.T, answer byte, cert tls.Certificate) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+
+				request := make([]byte, 8)
+				if _, err := io.ReadFull(conn, request); err != nil || binary.BigEndian.Uint32(request[4:]) != 80877103 {
+					return
+				}
+
+				if _, err := conn.Write([]byte{answer}); err != nil || answer != 'S' {
+					return
+				}
+
+				tc := tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{cert}})
+				if tc.Handshake() != nil {
+					return
+				}
+
+				//подтверждение, что данные идут через TLS.
+				_, _ = tc.Write([]byte("R"))
+			}()
+		}
+	}()
+
+	return l.Addr().String()
+}
+
+// dialTLS подключается к address с настройками settings, проверяя сертификат на имя host.
+func dialTLS(t *testing.T, settings TLS, host, address string) error {
+	t.Helper()
+
+	cfg, err := settings.config(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	d := pqDialer{dialer: &net.Dialer{}, tls: cfg}
+
+	conn, err := d.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	reply := make([]byte, 1)
+	if _, err = io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+
+	if reply[0] != 'R' {
+		t.Fatalf("reply = %q", reply)
+	}
+
+	return nil
+}
+
+func TestStartTLS(t *testing.T) {
+	ca, other := newTestCA(t), newTestCA(t)
+
+	address := serveTLS(t, 'S', ca.issue(t, "db.local"))
+
+	tests := []struct {
+		name    string
+		tls     TLS
+		host    string
+		wantErr string
+	}{
+		{
+			name: "require accepts any certificate",
+			tls:  TLS{SSLMode: SSLRequire},
+			host: "db.local",
+		},
+		{
+			name: "verify-ca ignores the name",
+			tls:  TLS{SSLMode: SSLVerifyCA, SSLRootCert: ca.pem},
+			host: "other.local",
+		},
+		{
+			name:    "verify-ca rejects an unknown CA",
+			tls:     TLS{SSLMode: SSLVerifyCA, SSLRootCert: other.pem},
+			host:    "db.local",
+			wantErr: "unknown authority",
+		},
+		{
+			name: "verify-full",
+			tls:  TLS{SSLMode: SSLVerifyFull, SSLRootCert: ca.pem},
+			host: "db.local",
+		},
+		{
+			name: "verify-full with server name",
+			tls:  TLS{SSLMode: SSLVerifyFull, SSLRootCert: ca.pem, SSLServerName: "db.local"},
+			host: "127.0.0.1",
+		},
+		{
+			name:    "verify-full rejects a name mismatch",
+			tls:     TLS{SSLMode: SSLVerifyFull, SSLRootCert: ca.pem},
+			host:    "other.local",
+			wantErr: "other.local",
+		},
+		{
+			name:    "verify-full rejects an unknown CA",
+			tls:     TLS{SSLMode: SSLVerifyFull, SSLRootCert: other.pem},
+			host:    "db.local",
+			wantErr: "unknown authority",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := dialTLS(t, tt.tls, tt.host, address)
+
+			switch {
+			case len(tt.wantErr) == 0 && err != nil:
+				t.Fatalf("err = %v", err)
+			case len(tt.wantErr) != 0 && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestStartTLSUnsupported(t *testing.T) {
+	ca := newTestCA(t)
+
+	address := serveTLS(t, 'N', ca.issue(t, "db.local"))
+
+	err := dialTLS(t, TLS{SSLMode: SSLRequire}, "db.local", address)
+	if err == nil || !strings.Contains(err.Error(), "не поддерживает TLS") {
+		t.Fatalf("err = %v, want TLS unsupported", err)
+	}
+}
+
+func TestTLSConfigInvalid(t *testing.T) {
+	for _, settings := range []TLS{
+		{SSLMode: "prefer"},
+		{SSLMode: SSLVerifyCA, SSLRootCert: "not a pem"},
+		{SSLMode: SSLRequire, SSLCert: "not a pem", SSLKey: "not a pem"},
+	} {
+		if _, err := settings.config("db.local"); err == nil {
+			t.Errorf("config(%+v) err = nil", settings)
+		}
+	}
+}
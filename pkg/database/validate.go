@@ -0,0 +1,122 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Validate проверяет, не исполняя запрос, что его таблицы и столбцы есть в схеме источника.
+func (db *Database) Validate(ctx context.Context, query Query) error {
+	switch query.Type {
+	case Select, Insert, Update, Delete:
+	default:
+		return fmt.Errorf("неизвестный тип запроса %s", query.Type)
+	}
+
+	if query.Table == nil {
+		return errors.New("не указана таблица запроса")
+	}
+
+	if err := checkWriteOperators(query); err != nil {
+		return err
+	}
+
+	//значения параметров известны только при исполнении.
+	query, err := query.checkParameters()
+	if err != nil {
+		return err
+	}
+
+	//столбцы таблиц запроса по ключам таблиц.
+	tables := make(map[QTableKey]map[string]bool)
+
+	var walk func(t *QTable) error
+	walk = func(t *QTable) error {
+		table, err := db.table(ctx, t.Name)
+		if err != nil {
+			return err
+		}
+
+		columns := make(map[string]bool, len(table.Columns))
+		for _, c := range table.Columns {
+			columns[c.Name] = true
+		}
+		tables[t.QTableKey] = columns
+
+		for _, next := range t.Next {
+			if err = walk(next); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	if err = walk(query.Table); err != nil {
+		return err
+	}
+
+	check := func(qcs ...*QColumn) error {
+		for _, c := range qcs {
+			if c == nil {
+				return errors.New("пустой столбец в запросе")
+			}
+
+			columns, ok := tables[c.TableKey]
+			if !ok {
+				return fmt.Errorf("столбец %s относится к таблице %s, которой нет в запросе", c.Name, c.TableKey.String())
+			}
+
+			if !columns[c.Name] {
+				return fmt.Errorf("в таблице %s нет столбца %s", c.TableKey.Name, c.Name)
+			}
+		}
+
+		return nil
+	}
+
+	var checkRules func(t *QTable) error
+	checkRules = func(t *QTable) error {
+		if t.Rule != nil {
+			for _, condition := range t.Rule.Conditions {
+				if err := check(condition.Columns[:]...); err != nil {
+					return err
+				}
+			}
+		}
+
+		for _, next := range t.Next {
+			if err := checkRules(next); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	if err = checkRules(query.Table); err != nil {
+		return err
+	}
+
+	for _, c := range query.Where {
+		if _, err = c.operator(); err != nil {
+			return err
+		}
+	}
+
+	for _, qcs := range [][]*QColumn{query.Columns, query.Where, query.OrderBy} {
+		if err := check(qcs...); err != nil {
+			return err
+		}
+	}
+
+	//остальные ошибки select, например неверные правила объединения, находит компиляция.
+	if query.Type == Select {
+		if _, _, err := db.Compile(ctx, query); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,134 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// логические типы значений результата, которых нет среди типов столбцов.
+const (
+	DateTimeJSON = "datetime"
+	DateJSON     = "date"
+)
+
+// ResultColumn - описание столбца результата select.
+type ResultColumn struct {
+	Name      string `json:"name"`      //ключ значения в строке результата.
+	Type      string `json:"type"`      //логический тип.
+	Source    string `json:"source"`    //столбец источника в виде таблица.столбец.
+	Func      string `json:"func"`      //агрегатная функция.
+	BigNumber bool   `json:"bigNumber"` //часть чисел передана строками, чтобы не потерять точность.
+	DbType    string `json:"dbType"`    //имя типа драйвера.
+}
+
+// maxSafeInteger - наибольшее целое, которое представимо в JavaScript без потери точности.
+const maxSafeInteger = 1<<53 - 1
+
+// maxSafeDigits - количество значащих цифр, которые сохраняет float64.
+const maxSafeDigits = 15
+
+// logicalType возвращает логический тип по имени типа драйвера.
+func logicalType(dbType string) string {
+	switch dbType {
+	case "INT2", "INT4", "INT8", "OID", "FLOAT4", "FLOAT8", "NUMERIC":
+		return NumberJSON
+	case "BOOL":
+		return BooleanJSON
+	case "TIMESTAMP", "TIMESTAMPTZ":
+		return DateTimeJSON
+	case "DATE":
+		return DateJSON
+	default:
+		return StringJSON
+	}
+}
+
+// newResultColumns описывает видимые столбцы результата.
+func newResultColumns(query Query, types []*sql.ColumnType) []ResultColumn {
+	sources := make(map[string]*QColumn, len(query.Columns))
+	for _, column := range query.Columns {
+		sources[column.String()] = column
+	}
+
+	columns := make([]ResultColumn, 0, len(types))
+
+	for _, t := range types {
+		if strings.HasPrefix(t.Name(), specialRootPrefix) {
+			continue
+		}
+
+		c := ResultColumn{
+			Name:   t.Name(),
+			Type:   logicalType(t.DatabaseTypeName()),
+			DbType: t.DatabaseTypeName(),
+		}
+
+		if source, ok := sources[t.Name()]; ok {
+			c.Source = source.TableKey.String() + "." + source.Name
+			c.Func = source.Func
+		}
+
+		columns = append(columns, c)
+	}
+
+	return columns
+}
+
+// normalize приводит значение драйвера к значению JSON.
+// big = true, если число передано строкой, чтобы не потерять точность.
+func normalize(dbType string, value any, loc *time.Location) (result any, big bool) {
+	switch v := value.(type) {
+	case nil:
+		return nil, false
+
+	case int64:
+		if v > maxSafeInteger || v < -maxSafeInteger {
+			return strconv.FormatInt(v, 10), true
+		}
+		return v, false
+
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return strconv.FormatFloat(v, 'g', -1, 64), true
+		}
+		return v, false
+
+	case time.Time:
+		switch dbType {
+		case "DATE":
+			return v.Format(time.DateOnly), false
+		case "TIMESTAMP":
+			//без часового пояса значение не переводится.
+			return v.Format("2006-01-02T15:04:05.999999999"), false
+		default:
+			return v.In(loc).Format(time.RFC3339Nano), false
+		}
+
+	case []byte:
+		if dbType == "NUMERIC" {
+			return normalizeNumeric(string(v))
+		}
+		return string(v), false
+
+	default:
+		return v, false
+	}
+}
+
+func normalizeNumeric(text string) (any, bool) {
+	f, err := strconv.ParseFloat(text, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return text, true
+	}
+
+	digits := strings.TrimLeft(strings.NewReplacer("-", "", ".", "").Replace(text), "0")
+	if len(digits) > maxSafeDigits {
+		return text, true
+	}
+
+	return json.Number(text), false
+}
@@ -0,0 +1,184 @@
+package envelope
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// prefix отличает зашифрованные значения от сохраненных до включения шифрования.
+const prefix = "enc:v1:"
+
+// Keyring шифрует значения конвертом: каждое значение шифруется своим ключом данных AES-256-GCM,
+// а ключ данных - мастер-ключом. Значения, зашифрованные прежними мастер-ключами, расшифровываются,
+// пока эти ключи переданы в Keyring, а шифруются всегда текущим.
+type Keyring struct {
+	primary masterKey
+	keys    map[string]masterKey
+}
+
+type masterKey struct {
+	id   string //первые байты хэша ключа, сохраняются вместе со значением.
+	aead cipher.AEAD
+}
+
+// NewKeyring принимает мастер-ключи длиной 32 байта в base64, первый - текущий.
+func NewKeyring(primary string, previous ...string) (*Keyring, error) {
+	if len(primary) == 0 {
+		return nil, errors.New("не задан мастер-ключ")
+	}
+
+	k := Keyring{keys: make(map[string]masterKey, len(previous)+1)}
+
+	for i, encoded := range append([]string{primary}, previous...) {
+		mk, err := newMasterKey(encoded)
+		if err != nil {
+			return nil, err
+		}
+
+		if i == 0 {
+			k.primary = mk
+		}
+
+		k.keys[mk.id] = mk
+	}
+
+	return &k, nil
+}
+
+func newMasterKey(encoded string) (masterKey, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return masterKey{}, fmt.Errorf("мастер-ключ должен быть в base64: %s", err.Error())
+	}
+
+	if len(key) != 32 {
+		return masterKey{}, fmt.Errorf("длина мастер-ключа %d байт вместо 32", len(key))
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return masterKey{}, err
+	}
+
+	sum := sha256.Sum256(key)
+
+	return masterKey{id: hex.EncodeToString(sum[:4]), aead: aead}, nil
+}
+
+// GenerateKey возвращает новый мастер-ключ в base64.
+func GenerateKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// Encrypt шифрует значение текущим мастер-ключом, пустое значение остается пустым.
+func (k *Keyring) Encrypt(plain string) (string, error) {
+	if len(plain) == 0 {
+		return "", nil
+	}
+
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	var wrapped, sealed []byte
+
+	if wrapped, err = seal(k.primary.aead, dataKey); err != nil {
+		return "", err
+	}
+
+	if sealed, err = seal(aead, []byte(plain)); err != nil {
+		return "", err
+	}
+
+	return prefix + k.primary.id + ":" +
+		base64.RawStdEncoding.EncodeToString(wrapped) + ":" +
+		base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt расшифровывает значение, значение без шифрования возвращается как есть.
+func (k *Keyring) Decrypt(value string) (string, error) {
+	if !strings.HasPrefix(value, prefix) {
+		return value, nil
+	}
+
+	parts := strings.Split(strings.TrimPrefix(value, prefix), ":")
+	if len(parts) != 3 {
+		return "", errors.New("поврежденное зашифрованное значение")
+	}
+
+	mk, ok := k.keys[parts[0]]
+	if !ok {
+		return "", fmt.Errorf("значение зашифровано неизвестным мастер-ключом %s", parts[0])
+	}
+
+	wrapped, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", errors.New("поврежденное зашифрованное значение")
+	}
+
+	var sealed, dataKey, plain []byte
+
+	if sealed, err = base64.RawStdEncoding.DecodeString(parts[2]); err != nil {
+		return "", errors.New("поврежденное зашифрованное значение")
+	}
+
+	if dataKey, err = open(mk.aead, wrapped); err != nil {
+		return "", fmt.Errorf("не удалось расшифровать ключ данных: %s", err.Error())
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	if plain, err = open(aead, sealed); err != nil {
+		return "", fmt.Errorf("не удалось расшифровать значение: %s", err.Error())
+	}
+
+	return string(plain), nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// seal возвращает nonce и шифротекст одним срезом.
+func seal(aead cipher.AEAD, plain []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plain)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plain, nil), nil
+}
+
+func open(aead cipher.AEAD, sealed []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("слишком короткий шифротекст")
+	}
+
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+}
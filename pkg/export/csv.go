@@ -0,0 +1,45 @@
+package export
+
+import (
+	"datapointbackend/pkg/database"
+	"encoding/csv"
+	"fmt"
+	"io"
+)
+
+type csvWriter struct {
+	w      *csv.Writer
+	record []string
+}
+
+func newCSVWriter(w io.Writer) *csvWriter {
+	return &csvWriter{w: csv.NewWriter(w)}
+}
+
+func (w *csvWriter) WriteHeader(columns []database.ResultColumn) error {
+	w.record = make([]string, len(columns))
+	for i, c := range columns {
+		w.record[i] = c.Name
+	}
+	return w.w.Write(w.record)
+}
+
+func (w *csvWriter) WriteRow(values []any) error {
+	for i, value := range values {
+		if value == nil {
+			w.record[i] = ""
+			continue
+		}
+		w.record[i] = fmt.Sprint(value)
+	}
+	return w.w.Write(w.record)
+}
+
+func (w *csvWriter) Close() error {
+	w.w.Flush()
+	return w.w.Error()
+}
+
+func (w *csvWriter) Abort(error) error {
+	return ErrAbort
+}
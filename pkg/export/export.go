@@ -0,0 +1,63 @@
+package export
+
+import (
+	"datapointbackend/pkg/database"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	CSV    = "csv"
+	NDJSON = "ndjson"
+	XLSX   = "xlsx"
+)
+
+// Writer построчно записывает результат select в формате выгрузки.
+type Writer interface {
+	WriteHeader(columns []database.ResultColumn) error
+	WriteRow(values []any) error
+	Close() error //дописывает окончание файла, но не закрывает io.Writer.
+	//Abort дописывает в конец выгрузки ошибку, которая ее прервала,
+	//или возвращает ErrAbort, если формат не может сообщить об ошибке.
+	Abort(err error) error
+}
+
+// ErrAbort - формат не может сообщить об ошибке внутри файла, поэтому прерванную выгрузку
+// нужно оборвать вместе с соединением, чтобы она не выглядела как законченный файл.
+var ErrAbort = errors.New("формат выгрузки не может сообщить об ошибке")
+
+func New(format string, w io.Writer) (Writer, error) {
+	switch format {
+	case CSV:
+		return newCSVWriter(w), nil
+	case NDJSON:
+		return newNDJSONWriter(w), nil
+	case XLSX:
+		return newXLSXWriter(w)
+	default:
+		return nil, fmt.Errorf("неизвестный формат выгрузки %s", format)
+	}
+}
+
+func ContentType(format string) string {
+	switch format {
+	case CSV:
+		return "text/csv; charset=utf-8"
+	case NDJSON:
+		return "application/x-ndjson"
+	case XLSX:
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+func Supported(format string) bool {
+	switch format {
+	case CSV, NDJSON, XLSX:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,259 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"datapointbackend/pkg/database"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAbort(t *testing.T) {
+	columns := []database.ResultColumn{{Name: "id"}, {Name: "name"}}
+
+	for _, format := range []string{CSV, NDJSON, XLSX} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+
+			w, err := New(format, &buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err = w.WriteHeader(columns); err != nil {
+				t.Fatal(err)
+			}
+
+			if err = w.WriteRow([]any{1, "Иван"}); err != nil {
+				t.Fatal(err)
+			}
+
+			err = w.Abort(errors.New("истекло время запроса"))
+
+			if format != NDJSON {
+				if !errors.Is(err, ErrAbort) {
+					t.Fatalf("err = %v, want ErrAbort", err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+			if len(lines) != 2 {
+				t.Fatalf("lines = %q, want row and error", lines)
+			}
+
+			var last map[string]any
+			if err = json.Unmarshal([]byte(lines[1]), &last); err != nil {
+				t.Fatal(err)
+			}
+
+			if last[ndjsonError] != "истекло время запроса" {
+				t.Errorf("last line = %s, want error marker", lines[1])
+			}
+		})
+	}
+}
+
+// exportColumns и exportRows - результат со значениями всех типов, NULL и символами, которые нужно экранировать.
+var exportColumns = []database.ResultColumn{
+	{Name: "id", Type: database.NumberJSON},
+	{Name: "name", Type: database.StringJSON},
+	{Name: "active", Type: database.BooleanJSON},
+	{Name: "born", Type: database.DateJSON},
+	{Name: "seen", Type: database.DateTimeJSON},
+	{Name: "balance", Type: database.NumberJSON, BigNumber: true},
+	{Name: "ratio", Type: database.NumberJSON},
+}
+
+var exportRows = [][]any{
+	{int64(1), `Иван "Ваня", <admin> & co` + "\nвторая строка", true, "2024-03-01", "2024-03-01T12:00:00+03:00", json.Number("12345678901234567890"), 0.5},
+	{int64(2), nil, false, nil, nil, nil, nil},
+}
+
+// write записывает exportRows в формате format.
+func write(t *testing.T, format string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+
+	w, err := New(format, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = w.WriteHeader(exportColumns); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, row := range exportRows {
+		if err = w.WriteRow(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestCSV(t *testing.T) {
+	records, err := csv.NewReader(bytes.NewReader(write(t, CSV))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := [][]string{
+		{"id", "name", "active", "born", "seen", "balance", "ratio"},
+		{"1", `Иван "Ваня", <admin> & co` + "\nвторая строка", "true", "2024-03-01", "2024-03-01T12:00:00+03:00", "12345678901234567890", "0.5"},
+		//NULL - пустое поле.
+		{"2", "", "false", "", "", "", ""},
+	}
+
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("records = %q, want %q", records, want)
+	}
+}
+
+func TestNDJSON(t *testing.T) {
+	lines := strings.Split(strings.TrimSuffix(string(write(t, NDJSON)), "\n"), "\n")
+	if len(lines) != len(exportRows) {
+		t.Fatalf("lines = %q", lines)
+	}
+
+	//ключи идут в порядке столбцов.
+	if !strings.HasPrefix(lines[0], `{"id":1,"name":`) || !strings.HasSuffix(lines[0], `"balance":12345678901234567890,"ratio":0.5}`) {
+		t.Errorf("line = %s", lines[0])
+	}
+
+	want := []map[string]any{
+		{
+			"id": json.Number("1"), "name": `Иван "Ваня", <admin> & co` + "\nвторая строка", "active": true,
+			"born": "2024-03-01", "seen": "2024-03-01T12:00:00+03:00",
+			"balance": json.Number("12345678901234567890"), "ratio": json.Number("0.5"),
+		},
+		{"id": json.Number("2"), "name": nil, "active": false, "born": nil, "seen": nil, "balance": nil, "ratio": nil},
+	}
+
+	for i, line := range lines {
+		d := json.NewDecoder(strings.NewReader(line))
+		d.UseNumber()
+
+		var row map[string]any
+		if err := d.Decode(&row); err != nil {
+			t.Fatalf("line %d: %v", i, err)
+		}
+
+		if !reflect.DeepEqual(row, want[i]) {
+			t.Errorf("row %d = %v, want %v", i, row, want[i])
+		}
+	}
+}
+
+// xlsxCell - ячейка листа: тип, стиль, значение и строка inlineStr.
+type xlsxCell struct {
+	Ref    string `xml:"r,attr"`
+	Type   string `xml:"t,attr"`
+	Style  string `xml:"s,attr"`
+	Value  string `xml:"v"`
+	Inline string `xml:"is>t"`
+}
+
+func TestXLSX(t *testing.T) {
+	data := write(t, XLSX)
+
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := make(map[string][]byte)
+
+	for _, f := range zr.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		content, err := io.ReadAll(r)
+		_ = r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		//каждая часть книги - корректный XML.
+		var node struct{}
+		if err = xml.Unmarshal(content, &node); err != nil {
+			t.Errorf("%s: %v", f.Name, err)
+		}
+
+		parts[f.Name] = content
+	}
+
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels", "xl/styles.xml"} {
+		if _, ok := parts[name]; !ok {
+			t.Errorf("part %s is missing", name)
+		}
+	}
+
+	var sheet struct {
+		Rows []struct {
+			Ref   string     `xml:"r,attr"`
+			Cells []xlsxCell `xml:"c"`
+		} `xml:"sheetData>row"`
+	}
+
+	if err = xml.Unmarshal(parts["xl/worksheets/sheet1.xml"], &sheet); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sheet.Rows) != 3 {
+		t.Fatalf("rows = %d, want header and 2 rows", len(sheet.Rows))
+	}
+
+	header := sheet.Rows[0].Cells
+	if len(header) != len(exportColumns) || header[0] != (xlsxCell{Ref: "A1", Type: "inlineStr", Inline: "id"}) {
+		t.Errorf("header = %+v", header)
+	}
+
+	want := []xlsxCell{
+		{Ref: "A2", Value: "1"},
+		{Ref: "B2", Type: "inlineStr", Inline: `Иван "Ваня", <admin> & co` + "\nвторая строка"},
+		{Ref: "C2", Type: "b", Value: "1"},
+		//даты - числа дней Excel со стилем даты, время на часах без часового пояса.
+		{Ref: "D2", Style: "1", Value: "45352"},
+		{Ref: "E2", Style: "2", Value: "45352.5"},
+		{Ref: "F2", Value: "12345678901234567890"},
+		{Ref: "G2", Value: "0.5"},
+	}
+
+	if !reflect.DeepEqual(sheet.Rows[1].Cells, want) {
+		t.Errorf("row 2 = %+v, want %+v", sheet.Rows[1].Cells, want)
+	}
+
+	//NULL - пропущенная ячейка.
+	want = []xlsxCell{{Ref: "A3", Value: "2"}, {Ref: "C3", Type: "b", Value: "0"}}
+
+	if sheet.Rows[2].Ref != "3" || !reflect.DeepEqual(sheet.Rows[2].Cells, want) {
+		t.Errorf("row 3 = %+v, want %+v", sheet.Rows[2].Cells, want)
+	}
+}
+
+func TestColumnRef(t *testing.T) {
+	for index, want := range map[int]string{0: "A", 25: "Z", 26: "AA", 51: "AZ", 52: "BA", 701: "ZZ", 702: "AAA"} {
+		if ref := columnRef(index); ref != want {
+			t.Errorf("columnRef(%d) = %s, want %s", index, ref, want)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package export
+
+import (
+	"bufio"
+	"datapointbackend/pkg/database"
+	"encoding/json"
+	"io"
+)
+
+type ndjsonWriter struct {
+	w    *bufio.Writer
+	keys [][]byte //ключи столбцов в формате JSON.
+}
+
+func newNDJSONWriter(w io.Writer) *ndjsonWriter {
+	return &ndjsonWriter{w: bufio.NewWriter(w)}
+}
+
+func (w *ndjsonWriter) WriteHeader(columns []database.ResultColumn) error {
+	w.keys = make([][]byte, len(columns))
+	for i, c := range columns {
+		key, err := json.Marshal(c.Name)
+		if err != nil {
+			return err
+		}
+		w.keys[i] = key
+	}
+	return nil
+}
+
+// WriteRow записывает строку объектом JSON, сохраняя порядок столбцов.
+func (w *ndjsonWriter) WriteRow(values []any) error {
+	_ = w.w.WriteByte('{')
+
+	for i, value := range values {
+		if i != 0 {
+			_ = w.w.WriteByte(',')
+		}
+
+		data, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+
+		_, _ = w.w.Write(w.keys[i])
+		_ = w.w.WriteByte(':')
+		_, _ = w.w.Write(data)
+	}
+
+	_, err := w.w.WriteString("}\n")
+	return err
+}
+
+func (w *ndjsonWriter) Close() error {
+	return w.w.Flush()
+}
+
+// ndjsonError - ключ строки с ошибкой, прервавшей выгрузку. Столбцы результата
+// не начинаются с $, поэтому строка данных не совпадет со строкой ошибки.
+const ndjsonError = "$error"
+
+// Abort дописывает последней строкой объект {"$error": "..."}.
+func (w *ndjsonWriter) Abort(err error) error {
+	data, marshalErr := json.Marshal(map[string]string{ndjsonError: err.Error()})
+	if marshalErr != nil {
+		return marshalErr
+	}
+
+	_, _ = w.w.Write(data)
+	_ = w.w.WriteByte('\n')
+
+	return w.w.Flush()
+}
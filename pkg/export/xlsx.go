@@ -0,0 +1,236 @@
+package export
+
+import (
+	"archive/zip"
+	"bufio"
+	"datapointbackend/pkg/database"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// xlsxMaxRows - наибольшее количество строк листа Excel.
+const xlsxMaxRows = 1048576
+
+// стили ячеек из xlsxStyles.
+const (
+	styleDate     = 1
+	styleDateTime = 2
+)
+
+var xlsxParts = []struct{ name, content string }{
+	{"[Content_Types].xml", xml.Header + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>` +
+		`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+		`</Types>`},
+	{"_rels/.rels", xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+		`</Relationships>`},
+	{"xl/workbook.xml", xml.Header + `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" ` +
+		`xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+		`<sheets><sheet name="data" sheetId="1" r:id="rId1"/></sheets></workbook>`},
+	{"xl/_rels/workbook.xml.rels", xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+		`<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>` +
+		`</Relationships>`},
+	{"xl/styles.xml", xml.Header + `<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+		`<fonts count="1"><font><sz val="11"/><name val="Calibri"/></font></fonts>` +
+		`<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>` +
+		`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>` +
+		`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+		`<cellXfs count="3">` +
+		`<xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>` +
+		`<xf numFmtId="14" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>` +
+		`<xf numFmtId="22" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>` +
+		`</cellXfs></styleSheet>`},
+}
+
+// xlsxWriter записывает единственный лист книги по мере поступления строк.
+type xlsxWriter struct {
+	zw      *zip.Writer
+	sheet   *bufio.Writer
+	columns []database.ResultColumn
+	refs    []string //буквенные обозначения столбцов.
+	row     int
+}
+
+func newXLSXWriter(w io.Writer) (*xlsxWriter, error) {
+	zw := zip.NewWriter(w)
+
+	for _, part := range xlsxParts {
+		pw, err := zw.Create(part.name)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err = io.WriteString(pw, part.content); err != nil {
+			return nil, err
+		}
+	}
+
+	sw, err := zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+
+	xw := xlsxWriter{zw: zw, sheet: bufio.NewWriter(sw)}
+
+	_, err = xw.sheet.WriteString(xml.Header +
+		`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+
+	return &xw, err
+}
+
+func (w *xlsxWriter) WriteHeader(columns []database.ResultColumn) error {
+	w.columns = columns
+	w.refs = make([]string, len(columns))
+
+	values := make([]any, len(columns))
+	for i, c := range columns {
+		w.refs[i] = columnRef(i)
+		values[i] = c.Name
+	}
+
+	return w.writeRow(values, false)
+}
+
+func (w *xlsxWriter) WriteRow(values []any) error {
+	return w.writeRow(values, true)
+}
+
+func (w *xlsxWriter) writeRow(values []any, typed bool) error {
+	if w.row == xlsxMaxRows {
+		return fmt.Errorf("превышено количество строк листа Excel %d", xlsxMaxRows)
+	}
+
+	w.row++
+
+	row := strconv.Itoa(w.row)
+
+	_, _ = w.sheet.WriteString(`<row r="` + row + `">`)
+
+	for i, value := range values {
+		if value == nil {
+			continue
+		}
+
+		ref := w.refs[i] + row
+
+		if !typed {
+			w.writeString(ref, fmt.Sprint(value))
+			continue
+		}
+
+		switch v := value.(type) {
+		case bool:
+			b := "0"
+			if v {
+				b = "1"
+			}
+			_, _ = w.sheet.WriteString(`<c r="` + ref + `" t="b"><v>` + b + `</v></c>`)
+
+		case int64:
+			w.writeNumber(ref, strconv.FormatInt(v, 10), 0)
+
+		case float64:
+			w.writeNumber(ref, strconv.FormatFloat(v, 'g', -1, 64), 0)
+
+		case json.Number:
+			w.writeNumber(ref, v.String(), 0)
+
+		case string:
+			if serial, style, ok := excelTime(w.columns[i].Type, v); ok {
+				w.writeNumber(ref, strconv.FormatFloat(serial, 'f', -1, 64), style)
+				continue
+			}
+			w.writeString(ref, v)
+
+		default:
+			w.writeString(ref, fmt.Sprint(v))
+		}
+	}
+
+	_, err := w.sheet.WriteString(`</row>`)
+	return err
+}
+
+func (w *xlsxWriter) writeNumber(ref, number string, style int) {
+	_, _ = w.sheet.WriteString(`<c r="` + ref + `"`)
+	if style != 0 {
+		_, _ = w.sheet.WriteString(` s="` + strconv.Itoa(style) + `"`)
+	}
+	_, _ = w.sheet.WriteString(`><v>` + number + `</v></c>`)
+}
+
+func (w *xlsxWriter) writeString(ref, s string) {
+	_, _ = w.sheet.WriteString(`<c r="` + ref + `" t="inlineStr"><is><t xml:space="preserve">`)
+	_ = xml.EscapeText(w.sheet, []byte(s))
+	_, _ = w.sheet.WriteString(`</t></is></c>`)
+}
+
+func (w *xlsxWriter) Close() error {
+	if _, err := w.sheet.WriteString(`</sheetData></worksheet>`); err != nil {
+		return err
+	}
+
+	if err := w.sheet.Flush(); err != nil {
+		return err
+	}
+
+	return w.zw.Close()
+}
+
+func (w *xlsxWriter) Abort(error) error {
+	return ErrAbort
+}
+
+// columnRef возвращает буквенное обозначение столбца: A, B, ..., Z, AA, ...
+func columnRef(index int) string {
+	var ref []byte
+	for index++; index > 0; index = (index - 1) / 26 {
+		ref = append([]byte{byte('A' + (index-1)%26)}, ref...)
+	}
+	return string(ref)
+}
+
+// excelEpoch - начало отсчета дат Excel с учетом ошибки високосного 1900 года.
+var excelEpoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+// excelTime переводит дату или дату и время в формате ISO 8601 в число дней Excel.
+// Учитывается время на часах, часовой пояс отбрасывается.
+func excelTime(logicalType, s string) (float64, int, bool) {
+	var (
+		layouts []string
+		style   int
+	)
+
+	switch logicalType {
+	case database.DateJSON:
+		layouts, style = []string{time.DateOnly}, styleDate
+	case database.DateTimeJSON:
+		layouts, style = []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999999"}, styleDateTime
+	default:
+		return 0, 0, false
+	}
+
+	for _, layout := range layouts {
+		t, err := time.Parse(layout, s)
+		if err != nil {
+			continue
+		}
+
+		wall := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+
+		seconds := float64(wall.Unix()-excelEpoch.Unix()) + float64(wall.Nanosecond())/1e9
+
+		return seconds / 86400, style, true
+	}
+
+	return 0, 0, false
+}
@@ -0,0 +1,194 @@
+package secret
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// DefaultEnvPrefix - префикс переменных окружения, на которые по умолчанию могут ссылаться источники.
+const DefaultEnvPrefix = "DATAPOINT_SOURCE_"
+
+// Resolver получает значение секрета по имени, например из хранилища секретов.
+type Resolver interface {
+	Resolve(ctx context.Context, name string) (string, error)
+}
+
+// Validator проверяет имя секрета до обращения к нему. Ссылки приходят от пользователей,
+// поэтому Resolver, который читает что-то кроме своих секретов, должен реализовать Validator.
+type Validator interface {
+	Validate(name string) error
+}
+
+type ResolverFunc func(ctx context.Context, name string) (string, error)
+
+func (f ResolverFunc) Resolve(ctx context.Context, name string) (string, error) {
+	return f(ctx, name)
+}
+
+var (
+	mu        sync.RWMutex
+	resolvers = map[string]Resolver{
+		"env":  Env{Prefix: DefaultEnvPrefix}, //env:DATAPOINT_SOURCE_PG_PASS - переменная окружения.
+		"file": Files{},                       //file:pg - файл каталога секретов, по умолчанию запрещено.
+	}
+)
+
+// Register добавляет или заменяет способ получения секретов для ссылок вида scheme:name.
+func Register(scheme string, r Resolver) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	resolvers[scheme] = r
+}
+
+// Validate проверяет ссылку вида scheme:name, не получая секрет.
+func Validate(ref string) error {
+	_, _, err := lookup(ref)
+	return err
+}
+
+// Resolve возвращает значение секрета по ссылке вида scheme:name.
+// Значение не кэшируется, чтобы замененный секрет читался при следующем подключении.
+func Resolve(ctx context.Context, ref string) (string, error) {
+	r, name, err := lookup(ref)
+	if err != nil {
+		return "", err
+	}
+
+	value, err := r.Resolve(ctx, name)
+	if err != nil {
+		return "", fmt.Errorf("не удалось получить секрет %s: %s", ref, err.Error())
+	}
+
+	return value, nil
+}
+
+// lookup возвращает способ получения секрета по ссылке и имя секрета.
+func lookup(ref string) (Resolver, string, error) {
+	scheme, name, ok := strings.Cut(ref, ":")
+	if !ok || len(name) == 0 {
+		return nil, "", fmt.Errorf("ссылка на секрет %s должна иметь вид схема:имя", ref)
+	}
+
+	mu.RLock()
+	r, ok := resolvers[scheme]
+	mu.RUnlock()
+
+	if !ok {
+		return nil, "", fmt.Errorf("неизвестная схема ссылки на секрет %s", scheme)
+	}
+
+	if v, ok := r.(Validator); ok {
+		if err := v.Validate(name); err != nil {
+			return nil, "", fmt.Errorf("недопустимая ссылка на секрет %s: %s", ref, err.Error())
+		}
+	}
+
+	return r, name, nil
+}
+
+// Env - секреты в переменных окружения, имена которых начинаются с Prefix.
+// Без префикса ссылки на переменные окружения запрещены.
+type Env struct {
+	Prefix string
+}
+
+func (e Env) Validate(name string) error {
+	if len(e.Prefix) == 0 {
+		return errors.New("ссылки на переменные окружения запрещены")
+	}
+
+	if !strings.HasPrefix(name, e.Prefix) || len(name) == len(e.Prefix) {
+		return fmt.Errorf("имя переменной окружения должно начинаться с %s", e.Prefix)
+	}
+
+	return nil
+}
+
+func (e Env) Resolve(_ context.Context, name string) (string, error) {
+	if err := e.Validate(name); err != nil {
+		return "", err
+	}
+
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("переменная окружения %s не задана", name)
+	}
+
+	return value, nil
+}
+
+// Files - секреты в файлах каталога Dir, имя - путь относительно Dir или абсолютный путь внутри него.
+// Значение - содержимое файла без перевода строки в конце. Без каталога ссылки на файлы запрещены.
+type Files struct {
+	Dir string
+}
+
+func (f Files) Validate(name string) error {
+	_, _, err := f.path(name)
+	return err
+}
+
+func (f Files) Resolve(_ context.Context, name string) (string, error) {
+	dir, path, err := f.path(name)
+	if err != nil {
+		return "", err
+	}
+
+	//символическая ссылка в каталоге не должна вести за его пределы.
+	if dir, err = filepath.EvalSymlinks(dir); err != nil {
+		return "", err
+	}
+
+	if path, err = filepath.EvalSymlinks(path); err != nil {
+		return "", err
+	}
+
+	if !inside(dir, path) {
+		return "", fmt.Errorf("файл %s находится вне каталога секретов", name)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// path возвращает абсолютные пути каталога и файла секрета, если файл внутри каталога.
+func (f Files) path(name string) (dir, path string, err error) {
+	if len(f.Dir) == 0 {
+		return "", "", errors.New("ссылки на файлы запрещены: не задан каталог секретов")
+	}
+
+	if dir, err = filepath.Abs(f.Dir); err != nil {
+		return "", "", err
+	}
+
+	path = filepath.Clean(name)
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+
+	if !inside(dir, path) {
+		return "", "", fmt.Errorf("файл %s находится вне каталога секретов", name)
+	}
+
+	return dir, path, nil
+}
+
+// inside проверяет, что очищенный путь path находится внутри каталога dir, но не совпадает с ним.
+func inside(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+
+	return rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,175 @@
+package secret
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// use подменяет способы получения секретов на время теста.
+func use(t *testing.T, scheme string, r Resolver) {
+	t.Helper()
+
+	mu.Lock()
+	previous, ok := resolvers[scheme]
+	mu.Unlock()
+
+	Register(scheme, r)
+
+	t.Cleanup(func() {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if ok {
+			resolvers[scheme] = previous
+		} else {
+			delete(resolvers, scheme)
+		}
+	})
+}
+
+func TestResolveEnv(t *testing.T) {
+	t.Setenv("DATAPOINT_SOURCE_PG", "secret")
+	t.Setenv("HOME_SECRET", "home")
+
+	if value, err := Resolve(context.Background(), "env:DATAPOINT_SOURCE_PG"); err != nil || value != "secret" {
+		t.Fatalf("Resolve = %q, %v", value, err)
+	}
+
+	for _, ref := range []string{
+		"env:HOME_SECRET",
+		"env:DATAPOINT_SOURCE_",
+		"env:datapoint_source_pg",
+	} {
+		if err := Validate(ref); err == nil {
+			t.Errorf("Validate(%s) = nil", ref)
+		}
+
+		if value, err := Resolve(context.Background(), ref); err == nil {
+			t.Errorf("Resolve(%s) = %q", ref, value)
+		}
+	}
+
+	if _, err := Resolve(context.Background(), "env:DATAPOINT_SOURCE_MISSING"); err == nil {
+		t.Error("missing variable resolved")
+	}
+
+	//без префикса ссылки на переменные окружения запрещены.
+	use(t, "env", Env{})
+
+	if err := Validate("env:DATAPOINT_SOURCE_PG"); err == nil {
+		t.Error("env reference allowed without prefix")
+	}
+}
+
+func TestResolveFile(t *testing.T) {
+	root := t.TempDir()
+
+	dir := filepath.Join(root, "secrets")
+	if err := os.Mkdir(dir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, content := range map[string]string{
+		filepath.Join(dir, "pg"):      "secret\n",
+		filepath.Join(root, "passwd"): "outside",
+	} {
+		if err := os.WriteFile(name, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	//символическая ссылка внутри каталога на файл вне его.
+	if err := os.Symlink(filepath.Join(root, "passwd"), filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	use(t, "file", Files{Dir: dir})
+
+	for _, ref := range []string{"file:pg", "file:" + filepath.Join(dir, "pg"), "file:./sub/../pg"} {
+		if value, err := Resolve(context.Background(), ref); err != nil || value != "secret" {
+			t.Errorf("Resolve(%s) = %q, %v", ref, value, err)
+		}
+	}
+
+	for _, ref := range []string{
+		"file:../passwd",
+		"file:" + filepath.Join(root, "passwd"),
+		"file:" + dir,
+		"file:/etc/passwd",
+		"file:pg/../../passwd",
+	} {
+		if err := Validate(ref); err == nil {
+			t.Errorf("Validate(%s) = nil", ref)
+		}
+
+		if value, err := Resolve(context.Background(), ref); err == nil {
+			t.Errorf("Resolve(%s) = %q", ref, value)
+		}
+	}
+
+	if value, err := Resolve(context.Background(), "file:link"); err == nil || !strings.Contains(err.Error(), "вне каталога") {
+		t.Errorf("Resolve(file:link) = %q, %v, want outside error", value, err)
+	}
+
+	//без каталога ссылки на файлы запрещены.
+	use(t, "file", Files{})
+
+	if err := Validate("file:" + filepath.Join(dir, "pg")); err == nil {
+		t.Error("file reference allowed without directory")
+	}
+}
+
+// fakeResolver - хранилище секретов, которое принимает только имена с префиксом vault/.
+type fakeResolver map[string]string
+
+func (f fakeResolver) Resolve(_ context.Context, name string) (string, error) {
+	value, ok := f[name]
+	if !ok {
+		return "", errors.New("not found")
+	}
+	return value, nil
+}
+
+func (f fakeResolver) Validate(name string) error {
+	if !strings.HasPrefix(name, "vault/") {
+		return errors.New("outside vault")
+	}
+	return nil
+}
+
+func TestResolveRegistered(t *testing.T) {
+	use(t, "vault", fakeResolver{"vault/pg": "secret"})
+
+	if value, err := Resolve(context.Background(), "vault:vault/pg"); err != nil || value != "secret" {
+		t.Fatalf("Resolve = %q, %v", value, err)
+	}
+
+	if err := Validate("vault:other/pg"); err == nil {
+		t.Error("Validate accepted a name the resolver rejects")
+	}
+
+	if _, err := Resolve(context.Background(), "vault:vault/missing"); err == nil {
+		t.Error("missing secret resolved")
+	}
+
+	//способ без Validator принимает любые имена.
+	use(t, "func", ResolverFunc(func(_ context.Context, name string) (string, error) {
+		return strings.ToUpper(name), nil
+	}))
+
+	if value, err := Resolve(context.Background(), "func:pg"); err != nil || value != "PG" {
+		t.Fatalf("Resolve = %q, %v", value, err)
+	}
+}
+
+func TestValidateMalformed(t *testing.T) {
+	for _, ref := range []string{"", "env", "env:", "unknown:name"} {
+		if err := Validate(ref); err == nil {
+			t.Errorf("Validate(%q) = nil", ref)
+		}
+	}
+}
@@ -13,8 +13,24 @@ CREATE TABLE source (
                         port INTEGER NOT NULL,
                         username TEXT NOT NULL,
                         password TEXT,
+                        password_ref TEXT NOT NULL DEFAULT '',
                         database_name TEXT NOT NULL,
-                        driver TEXT NOT NULL
+                        driver TEXT NOT NULL,
+                        default_timeout INTEGER NOT NULL DEFAULT 0,
+                        max_timeout INTEGER NOT NULL DEFAULT 0,
+                        max_rows BIGINT NOT NULL DEFAULT 0,
+                        max_bytes BIGINT NOT NULL DEFAULT 0,
+                        cache_ttl INTEGER NOT NULL DEFAULT 0,
+                        max_open_conns INTEGER NOT NULL DEFAULT 0,
+                        max_idle_conns INTEGER NOT NULL DEFAULT 0,
+                        conn_max_lifetime INTEGER NOT NULL DEFAULT 0,
+                        conn_max_idle_time INTEGER NOT NULL DEFAULT 0,
+                        ssl_mode TEXT NOT NULL DEFAULT 'disable',
+                        ssl_root_cert TEXT NOT NULL DEFAULT '',
+                        ssl_cert TEXT NOT NULL DEFAULT '',
+                        ssl_key TEXT NOT NULL DEFAULT '',
+                        ssl_server_name TEXT NOT NULL DEFAULT '',
+                        tunnel JSONB
 );
 
 CREATE TABLE widget (
@@ -28,7 +44,8 @@ CREATE TABLE widget (
 
 CREATE TABLE dashboard (
                            id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
-                           name TEXT NOT NULL
+                           name TEXT NOT NULL,
+                           filters JSONB
 );
 
 CREATE TABLE dashboard_widget (
@@ -37,5 +54,44 @@ CREATE TABLE dashboard_widget (
                                   x SMALLINT NOT NULL,
                                   y SMALLINT NOT NULL,
                                   w SMALLINT NOT NULL,
-                                  h SMALLINT NOT NULL
+                                  h SMALLINT NOT NULL,
+                                  bindings JSONB,
+                                  emits JSONB,
+                                  consumes JSONB
 );
+
+CREATE TABLE audit (
+                       id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+                       source_id UUID NOT NULL,
+                       type TEXT NOT NULL,
+                       table_name TEXT NOT NULL,
+                       query JSONB NOT NULL,
+                       raw_sql TEXT NOT NULL,
+                       args JSONB,
+                       rows_affected BIGINT NOT NULL,
+                       before JSONB,
+                       after JSONB,
+                       caller TEXT NOT NULL,
+                       caller_user TEXT NOT NULL DEFAULT '',
+                       status TEXT NOT NULL DEFAULT 'committed',
+                       created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX audit_source_id_created_at_idx ON audit (source_id, created_at);
+
+CREATE TABLE schema_snapshot (
+                                 id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+                                 source_id UUID NOT NULL REFERENCES source (id) ON DELETE CASCADE,
+                                 tables JSONB NOT NULL,
+                                 created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX schema_snapshot_source_id_created_at_idx ON schema_snapshot (source_id, created_at DESC);
+
+CREATE TABLE migration (
+                           name TEXT PRIMARY KEY,
+                           applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+--в новой базе переписывать нечего.
+INSERT INTO migration (name) VALUES ('widget_queries');
@@ -0,0 +1,69 @@
+--обновляет существующую базу до схемы init_internal.sql, повторный запуск ничего не меняет.
+--после него нужно переписать сохраненные запросы виджетов: go run ./cmd/migratewidgets.
+
+\c datapoint
+
+SET CLIENT_ENCODING = 'UTF-8';
+
+ALTER TABLE source
+    ADD COLUMN IF NOT EXISTS password_ref TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS default_timeout INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS max_timeout INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS max_rows BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS max_bytes BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS cache_ttl INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS max_open_conns INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS max_idle_conns INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS conn_max_lifetime INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS conn_max_idle_time INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS ssl_mode TEXT NOT NULL DEFAULT 'disable',
+    ADD COLUMN IF NOT EXISTS ssl_root_cert TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS ssl_cert TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS ssl_key TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS ssl_server_name TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS tunnel JSONB;
+
+ALTER TABLE dashboard
+    ADD COLUMN IF NOT EXISTS filters JSONB;
+
+ALTER TABLE dashboard_widget
+    ADD COLUMN IF NOT EXISTS bindings JSONB,
+    ADD COLUMN IF NOT EXISTS emits JSONB,
+    ADD COLUMN IF NOT EXISTS consumes JSONB;
+
+CREATE TABLE IF NOT EXISTS audit (
+                                     id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+                                     source_id UUID NOT NULL,
+                                     type TEXT NOT NULL,
+                                     table_name TEXT NOT NULL,
+                                     query JSONB NOT NULL,
+                                     raw_sql TEXT NOT NULL,
+                                     args JSONB,
+                                     rows_affected BIGINT NOT NULL,
+                                     before JSONB,
+                                     after JSONB,
+                                     caller TEXT NOT NULL,
+                                     created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+--записи, сделанные до появления статуса, относятся к зафиксированным изменениям.
+ALTER TABLE audit
+    ADD COLUMN IF NOT EXISTS caller_user TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'committed';
+
+CREATE INDEX IF NOT EXISTS audit_source_id_created_at_idx ON audit (source_id, created_at);
+
+CREATE TABLE IF NOT EXISTS schema_snapshot (
+                                               id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+                                               source_id UUID NOT NULL REFERENCES source (id) ON DELETE CASCADE,
+                                               tables JSONB NOT NULL,
+                                               created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS schema_snapshot_source_id_created_at_idx ON schema_snapshot (source_id, created_at DESC);
+
+--отметку widget_queries ставит migratewidgets после переписывания запросов.
+CREATE TABLE IF NOT EXISTS migration (
+                                         name TEXT PRIMARY KEY,
+                                         applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);